@@ -43,7 +43,7 @@ func main() {
 	}
 	source := string(b)
 
-	result, err := golfcart.RunProgram(source, *debug)
+	result, err := golfcart.RunProgramFile(source, file, *debug)
 	if err != nil {
 		fmt.Printf("while running %v: %v\n", file, err)
 		os.Exit(1)
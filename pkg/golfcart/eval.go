@@ -1,25 +1,125 @@
 package golfcart
 
 import (
+	"bufio"
+	"bytes"
+	gocontext "context"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
 type Context struct {
 	stackFrame StackFrame
+	stdin      *lineReader
+	stdout     io.Writer
+	stderr     io.Writer
+	ctx        gocontext.Context
+	limits     Limits
+	steps      int
+	callDepth  int
+	allocBytes int
+	modules    *moduleCache
+	runtime    []string
+	// root is the context of the program that imported this module, if
+	// it is one. Limits, cancellation and streams all go through it.
+	root *Context
 }
 
 func (context *Context) Init() {
-	context.stackFrame = StackFrame{entries: make(map[string]Value)}
+	context.stackFrame = StackFrame{entries: make(map[string]Value), context: context}
+	context.stdin = osStdinReader()
+	context.stdout = os.Stdout
+	context.stderr = os.Stderr
+	context.ctx = gocontext.Background()
+	context.modules = newModuleCache()
+}
+
+// SetContext sets the Go context that for loops and calls check for
+// cancellation. Once it is done, evaluation stops with a CancelledError.
+func (context *Context) SetContext(ctx gocontext.Context) {
+	context.ctx = ctx
+}
+
+func (context *Context) checkCancelled(pos lexer.Position) error {
+	if err := context.rootContext().ctx.Err(); err != nil {
+		return CancelledError{Pos: pos, Err: err}
+	}
+	return nil
+}
+
+// SetStdin sets the stream that in() and the REPL read lines from.
+func (context *Context) SetStdin(stdin io.Reader) {
+	if stdin == os.Stdin {
+		context.stdin = osStdinReader()
+		return
+	}
+	context.stdin = &lineReader{scanner: bufio.NewScanner(stdin)}
+}
+
+// lineReader reads lines from a stream. A scanner buffers ahead of the
+// line it returns, so every context reading the process's stdin shares
+// one reader instead of losing each other's buffered input. Any other
+// stream belongs to the context it was set on, and to its modules.
+type lineReader struct {
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+}
+
+var stdinReader struct {
+	sync.Mutex
+	file   *os.File
+	reader *lineReader
+}
+
+// osStdinReader returns the shared reader for os.Stdin, starting a new one
+// if the host has replaced os.Stdin since it was made.
+func osStdinReader() *lineReader {
+	stdinReader.Lock()
+	defer stdinReader.Unlock()
+	if stdinReader.reader == nil || stdinReader.file != os.Stdin {
+		stdinReader.file = os.Stdin
+		stdinReader.reader = &lineReader{scanner: bufio.NewScanner(os.Stdin)}
+	}
+	return stdinReader.reader
+}
+
+// readLine returns the next line, or io.EOF once the stream is done.
+func (reader *lineReader) readLine() (string, error) {
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	if !reader.scanner.Scan() {
+		if err := reader.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return reader.scanner.Text(), nil
+}
+
+// SetStdout sets the stream that log(), in() prompts, REPL results and debug dumps write to.
+func (context *Context) SetStdout(stdout io.Writer) {
+	context.stdout = stdout
+}
+
+// SetStderr sets the stream that REPL errors are written to.
+func (context *Context) SetStderr(stderr io.Writer) {
+	context.stderr = stderr
 }
 
 type StackFrame struct {
 	entries map[string]Value
 	parent  *StackFrame
+	context *Context
 }
 
 func (frame *StackFrame) String() string {
@@ -40,7 +140,7 @@ func (frame *StackFrame) String() string {
 }
 
 func (frame *StackFrame) GetChild() *StackFrame {
-	childFrame := StackFrame{parent: frame, entries: make(map[string]Value)}
+	childFrame := StackFrame{parent: frame, entries: make(map[string]Value), context: frame.context}
 	return &childFrame
 }
 
@@ -118,6 +218,11 @@ func unwrap(value Value, frame *StackFrame) (Value, error) {
 
 type NilValue struct{}
 
+// NewNil returns the Golfcart nil value.
+func NewNil() NilValue {
+	return NilValue{}
+}
+
 func (numberValue NilValue) String() string {
 	return "nil"
 }
@@ -149,22 +254,78 @@ func (idValue IdentifierValue) Eval(frame *StackFrame) (Value, error) {
 	return value, nil
 }
 
+// NumberValue is either an integer or a float. val is always set, so code
+// that only needs a float can ignore the kind.
 type NumberValue struct {
-	val float64
+	val   float64
+	isInt bool
+	ival  int64
+	big   *big.Int
+}
+
+// NewNumber wraps a Go float64 as a Golfcart number.
+func NewNumber(n float64) NumberValue {
+	return NumberValue{val: n}
+}
+
+// NewInt wraps a Go int64 as a Golfcart integer.
+func NewInt(n int64) NumberValue {
+	return intValue(n)
+}
+
+// NewBigInt wraps a Go *big.Int as a Golfcart integer. n is copied.
+func NewBigInt(n *big.Int) NumberValue {
+	return bigValue(new(big.Int).Set(n))
+}
+
+// Val returns the number as a Go float64.
+func (numberValue NumberValue) Val() float64 {
+	return numberValue.val
+}
+
+// IsInt reports whether the number is an integer rather than a float.
+func (numberValue NumberValue) IsInt() bool {
+	return numberValue.isInt
+}
+
+// Int returns the number as a Go int64, truncating a float. Use Big for
+// integers that may not fit.
+func (numberValue NumberValue) Int() int64 {
+	if numberValue.big != nil {
+		return numberValue.big.Int64()
+	}
+	if numberValue.isInt {
+		return numberValue.ival
+	}
+	return int64(numberValue.val)
+}
+
+// Big returns an integer as a new *big.Int, or nil for a float.
+func (numberValue NumberValue) Big() *big.Int {
+	if !numberValue.isInt {
+		return nil
+	}
+	return new(big.Int).Set(numberValue.toBig())
 }
 
 func (numberValue NumberValue) String() string {
-	return nToS(numberValue.val)
+	return nvToS(numberValue)
 }
 
 func (numberValue NumberValue) Equals(other Value) (bool, error) {
 	if other, ok := unref(other).(NumberValue); ok {
-		return numberValue.val == other.val, nil
+		return numberCompare(numberValue, other) == 0 && !math.IsNaN(numberValue.val) && !math.IsNaN(other.val), nil
 	}
 	return false, nil
 }
 
 func nvToS(numberValue NumberValue) string {
+	if numberValue.big != nil {
+		return numberValue.big.String()
+	}
+	if numberValue.isInt {
+		return strconv.FormatInt(numberValue.ival, 10)
+	}
 	return nToS(numberValue.val)
 }
 
@@ -176,10 +337,45 @@ type StringValue struct {
 	val []byte
 }
 
+// NewString wraps a Go string as a Golfcart string.
+func NewString(s string) StringValue {
+	return StringValue{val: []byte(s)}
+}
+
+// Val returns the string as a Go string.
+func (stringValue StringValue) Val() string {
+	return string(stringValue.val)
+}
+
 func (stringValue StringValue) String() string {
 	return string(stringValue.val)
 }
 
+// Strings are indexed, iterated and measured by code point. codePoints
+// splits a string into them, keeping any byte that isn't valid UTF-8 as
+// its own one byte string.
+func codePoints(val []byte) [][]byte {
+	points := make([][]byte, 0, len(val))
+	for i := 0; i < len(val); {
+		_, size := utf8.DecodeRune(val[i:])
+		points = append(points, val[i:i+size])
+		i += size
+	}
+	return points
+}
+
+// codePointOffset returns the byte offset of the index-th code point in
+// val, or len(val) if there are fewer. It only walks as far as it needs
+// to, so indexing doesn't have to split the whole string.
+func codePointOffset(val []byte, index int) int {
+	i := 0
+	for ; index > 0 && i < len(val); index-- {
+		_, size := utf8.DecodeRune(val[i:])
+		i += size
+	}
+	return i
+}
+
 func (stringValue StringValue) Equals(other Value) (bool, error) {
 	if otherStr, ok := unref(other).(StringValue); ok {
 		a := stringValue.val
@@ -201,6 +397,16 @@ type BoolValue struct {
 	val bool
 }
 
+// NewBool wraps a Go bool as a Golfcart bool.
+func NewBool(b bool) BoolValue {
+	return BoolValue{val: b}
+}
+
+// Val returns the bool as a Go bool.
+func (boolValue BoolValue) Val() bool {
+	return boolValue.val
+}
+
 func (boolValue BoolValue) String() string {
 	return fmt.Sprintf("%t", boolValue.val)
 }
@@ -237,12 +443,125 @@ func (continueValue ContinueValue) Error() string {
 	return fmt.Sprintf("%v continue expression used outside of a for loop", continueValue.pos)
 }
 
+// CancelledError is returned when evaluation stops because the Go context
+// set with SetContext was cancelled or timed out.
+type CancelledError struct {
+	Pos lexer.Position
+	Err error
+}
+
+func (cancelledError CancelledError) Error() string {
+	return fmt.Sprintf("%v evaluation cancelled: %v", cancelledError.Pos, cancelledError.Err)
+}
+
+func (cancelledError CancelledError) Unwrap() error {
+	return cancelledError.Err
+}
+
+// RuntimeError is an error raised by evaluation at Pos, such as a type
+// error or a native function failing.
+type RuntimeError struct {
+	Pos lexer.Position
+	Err error
+}
+
+func runtimeErrorf(pos lexer.Position, format string, args ...interface{}) error {
+	return RuntimeError{Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+func (runtimeError RuntimeError) Error() string {
+	return fmt.Sprintf("%v %v", runtimeError.Pos, runtimeError.Err)
+}
+
+func (runtimeError RuntimeError) Unwrap() error {
+	return runtimeError.Err
+}
+
+// atPos gives an error that doesn't carry a position one. Errors that
+// already have a position, and control flow, are returned unchanged.
+func atPos(pos lexer.Position, err error) error {
+	switch err.(type) {
+	case nil, RuntimeError, ErrorValue, ReturnValue, BreakValue, ContinueValue, CancelledError, LimitError:
+		return err
+	}
+	return RuntimeError{Pos: pos, Err: err}
+}
+
+// ErrorValue is a runtime error as seen by Golfcart code. It's what a
+// catch block receives, and what throw() raises.
+type ErrorValue struct {
+	message string
+	kind    string
+	pos     lexer.Position
+	value   Value
+}
+
+// NewError creates an error value of the given kind, e.g. "runtime".
+func NewError(kind string, message string) ErrorValue {
+	return ErrorValue{message: message, kind: kind, value: NilValue{}}
+}
+
+// Message returns the error's message.
+func (errorValue ErrorValue) Message() string {
+	return errorValue.message
+}
+
+// Kind returns what raised the error: "runtime", "assert" or "thrown".
+func (errorValue ErrorValue) Kind() string {
+	return errorValue.kind
+}
+
+// Pos returns where the error was raised. It is the zero position for
+// errors made with NewError that haven't been raised yet.
+func (errorValue ErrorValue) Pos() lexer.Position {
+	return errorValue.pos
+}
+
+// Value returns the value passed to throw(), or NilValue for other errors.
+func (errorValue ErrorValue) Value() Value {
+	return errorValue.value
+}
+
+func (errorValue ErrorValue) String() string {
+	return "error: " + errorValue.message
+}
+
+func (errorValue ErrorValue) Error() string {
+	return fmt.Sprintf("%v %v", errorValue.pos, errorValue.message)
+}
+
+func (errorValue ErrorValue) Equals(other Value) (bool, error) {
+	if otherErr, okErr := unref(other).(ErrorValue); okErr {
+		return errorValue.kind == otherErr.kind && errorValue.message == otherErr.message &&
+			errorValue.pos == otherErr.pos, nil
+	}
+	return false, nil
+}
+
 type FunctionValue struct {
-	parameters  []string
+	pos         lexer.Position
+	parameters  []*Parameter
+	rest        string
 	frame       *StackFrame
 	expressions []*Expression
 }
 
+// Parameters returns the names of the function's parameters, not
+// including any rest parameter.
+func (functionValue FunctionValue) Parameters() []string {
+	names := make([]string, len(functionValue.parameters))
+	for i, parameter := range functionValue.parameters {
+		names[i] = parameter.Name
+	}
+	return names
+}
+
+// Rest returns the name of the function's rest parameter, or "" if it
+// doesn't take one.
+func (functionValue FunctionValue) Rest() string {
+	return functionValue.rest
+}
+
 func (functionValue FunctionValue) String() string {
 	return "function"
 }
@@ -252,12 +571,64 @@ func (functionValue FunctionValue) Equals(other Value) (bool, error) {
 }
 
 func (functionValue FunctionValue) Exec(args []Value) (Value, error) {
+	return functionValue.exec(args, nil)
+}
+
+// exec binds positional arguments in order, then named arguments, and
+// evaluates the default of any parameter still without a value.
+func (functionValue FunctionValue) exec(args []Value, named []namedArg) (Value, error) {
 	callFrame := functionValue.frame.GetChild()
-	if len(args) != len(functionValue.parameters) {
-		return nil, fmt.Errorf("function called with incorrect number of arguments, wanted: %v, got: %v", len(functionValue.parameters), formatValues(args))
+	parameters := functionValue.parameters
+	if functionValue.rest == "" && len(args) > len(parameters) {
+		return nil, fmt.Errorf("function called with too many arguments, wanted at most: %v, got: %v", len(parameters), formatValues(args))
+	}
+	values := make([]Value, len(parameters))
+	for i := 0; i < len(args) && i < len(parameters); i++ {
+		values[i] = args[i]
+	}
+	for _, arg := range named {
+		index := -1
+		for i, parameter := range parameters {
+			if parameter.Name == arg.name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, runtimeErrorf(arg.pos, "function has no parameter named '%v'", arg.name)
+		}
+		if values[index] != nil {
+			return nil, runtimeErrorf(arg.pos, "argument '%v' was given more than once", arg.name)
+		}
+		values[index] = arg.value
 	}
-	for i, parameter := range functionValue.parameters {
-		callFrame.Set(parameter, args[i])
+	for i, parameter := range parameters {
+		value := values[i]
+		if value == nil {
+			if parameter.Default == nil {
+				return nil, fmt.Errorf("function called without a value for parameter '%v', got: %v", parameter.Name, formatValues(args))
+			}
+			result, err := parameter.Default.Eval(callFrame)
+			if err != nil {
+				return nil, err
+			}
+			value, err = unwrap(result, callFrame)
+			if err != nil {
+				return nil, err
+			}
+			value = unref(value)
+		}
+		callFrame.Set(parameter.Name, value)
+	}
+	if functionValue.rest != "" {
+		var extra []Value
+		if len(args) > len(parameters) {
+			extra = args[len(parameters):]
+		}
+		if err := callFrame.context.alloc(functionValue.pos, len(extra)*approxValueBytes); err != nil {
+			return nil, err
+		}
+		callFrame.Set(functionValue.rest, NewList(extra))
 	}
 	var result Value
 	result = NilValue{}
@@ -275,6 +646,24 @@ type ListValue struct {
 	val map[int]*Value
 }
 
+// NewList creates a Golfcart list holding the given values in order.
+func NewList(values []Value) ListValue {
+	listValue := ListValue{val: make(map[int]*Value, len(values))}
+	for _, value := range values {
+		listValue.Append(value)
+	}
+	return listValue
+}
+
+// Val returns a copy of the list's items in order.
+func (listValue ListValue) Val() []Value {
+	values := make([]Value, len(listValue.val))
+	for i := range values {
+		values[i] = *listValue.val[i]
+	}
+	return values
+}
+
 func (listValue ListValue) String() string {
 	formatted := make([]string, len(listValue.val))
 	for i, item := range listValue.val {
@@ -284,7 +673,7 @@ func (listValue ListValue) String() string {
 }
 
 func (listValue ListValue) Equals(other Value) (bool, error) {
-	return false, nil
+	return deepEquals(listValue, other, make(map[[2]uintptr]bool))
 }
 
 func (listValue ListValue) Append(other Value) {
@@ -322,6 +711,24 @@ type DictValue struct {
 	val map[string]*Value
 }
 
+// NewDict creates a Golfcart dict holding the given entries.
+func NewDict(entries map[string]Value) DictValue {
+	dictValue := DictValue{val: make(map[string]*Value, len(entries))}
+	for key, value := range entries {
+		dictValue.Set(key, value)
+	}
+	return dictValue
+}
+
+// Val returns a copy of the dict's entries.
+func (dictValue DictValue) Val() map[string]Value {
+	entries := make(map[string]Value, len(dictValue.val))
+	for key, value := range dictValue.val {
+		entries[key] = *value
+	}
+	return entries
+}
+
 func (dictValue *DictValue) Get(key string) (*Value, error) {
 	value, ok := dictValue.val[key]
 	if ok {
@@ -354,7 +761,70 @@ func (dictValue DictValue) String() string {
 }
 
 func (dictValue DictValue) Equals(other Value) (bool, error) {
-	return false, nil
+	return deepEquals(dictValue, other, make(map[[2]uintptr]bool))
+}
+
+// deepEquals compares lists and dicts by their contents. seen holds the
+// pairs already being compared further up, which are assumed equal so that
+// cyclic values terminate.
+func deepEquals(value Value, other Value, seen map[[2]uintptr]bool) (bool, error) {
+	value, other = unref(value), unref(other)
+	switch value := value.(type) {
+	case ListValue:
+		otherList, okList := other.(ListValue)
+		if !okList || len(value.val) != len(otherList.val) {
+			return false, nil
+		}
+		pair := [2]uintptr{reflect.ValueOf(value.val).Pointer(), reflect.ValueOf(otherList.val).Pointer()}
+		if pair[0] == pair[1] || seen[pair] {
+			return true, nil
+		}
+		seen[pair] = true
+		for i := 0; i < len(value.val); i++ {
+			equal, err := deepEquals(*value.val[i], *otherList.val[i], seen)
+			if err != nil || !equal {
+				return false, err
+			}
+		}
+		return true, nil
+	case DictValue:
+		otherDict, okDict := other.(DictValue)
+		if !okDict || len(value.val) != len(otherDict.val) {
+			return false, nil
+		}
+		pair := [2]uintptr{reflect.ValueOf(value.val).Pointer(), reflect.ValueOf(otherDict.val).Pointer()}
+		if pair[0] == pair[1] || seen[pair] {
+			return true, nil
+		}
+		seen[pair] = true
+		for key, entry := range value.val {
+			otherEntry, ok := otherDict.val[key]
+			if !ok {
+				return false, nil
+			}
+			equal, err := deepEquals(*entry, *otherEntry, seen)
+			if err != nil || !equal {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return value.Equals(other)
+}
+
+// sameValue is identity: lists and dicts are only the same as themselves,
+// anything else is compared by value.
+func sameValue(value Value, other Value) (bool, error) {
+	value, other = unref(value), unref(other)
+	switch value := value.(type) {
+	case ListValue:
+		otherList, okList := other.(ListValue)
+		return okList && reflect.ValueOf(value.val).Pointer() == reflect.ValueOf(otherList.val).Pointer(), nil
+	case DictValue:
+		otherDict, okDict := other.(DictValue)
+		return okDict && reflect.ValueOf(value.val).Pointer() == reflect.ValueOf(otherDict.val).Pointer(), nil
+	}
+	return value.Equals(other)
 }
 
 // --
@@ -394,6 +864,12 @@ func (expr Expression) Equals(other Value) (bool, error) {
 }
 
 func (expr Expression) Eval(frame *StackFrame) (Value, error) {
+	if err := frame.context.step(expr.Pos); err != nil {
+		return nil, err
+	}
+	if expr.Destructure != nil {
+		return expr.Destructure.Eval(frame)
+	}
 	if expr.Assignment != nil {
 		result, err := expr.Assignment.Eval(frame)
 		if err != nil {
@@ -411,6 +887,105 @@ func (expr Expression) Eval(frame *StackFrame) (Value, error) {
 	panic("unimplemented Expression Eval")
 }
 
+func (destructure Destructure) String() string {
+	return "destructure"
+}
+
+func (destructure Destructure) Equals(other Value) (bool, error) {
+	return false, nil
+}
+
+func (destructure Destructure) Eval(frame *StackFrame) (Value, error) {
+	value, err := destructure.Value.Eval(frame)
+	if err != nil {
+		return nil, err
+	}
+	value, err = unwrap(value, frame)
+	if err != nil {
+		return nil, err
+	}
+	pattern := destructure.Patterns[0]
+	if len(destructure.Patterns) > 1 {
+		pattern = &Pattern{Pos: destructure.Pos, List: &ListPattern{Pos: destructure.Pos, Items: destructure.Patterns}}
+	}
+	if err := pattern.bind(value, frame); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (item Item) String() string {
+	return "item"
+}
+
+func (item Item) Equals(other Value) (bool, error) {
+	return false, nil
+}
+
+// Eval evaluates an Item as the Expression it stands for.
+func (item Item) Eval(frame *StackFrame) (Value, error) {
+	expr := Expression{Pos: item.Pos, Assignment: item.Assignment}
+	if destructure := item.Destructure; destructure != nil {
+		expr.Destructure = &Destructure{Pos: destructure.Pos, Patterns: []*Pattern{destructure.Pattern}, Value: destructure.Value}
+	}
+	return expr.Eval(frame)
+}
+
+// bind sets each variable named in the pattern to the matching part of
+// value. The shape must match exactly: a list pattern needs a list of the
+// same length, and a dict pattern needs every key it names.
+func (pattern Pattern) bind(value Value, frame *StackFrame) error {
+	value = unref(value)
+	if pattern.Ident != nil {
+		frame.Set(*pattern.Ident, value)
+		return nil
+	}
+	if listPattern := pattern.List; listPattern != nil {
+		listValue, okList := value.(ListValue)
+		if !okList {
+			return patternTypeError(listPattern.Pos, "list", value)
+		}
+		if len(listValue.val) != len(listPattern.Items) {
+			return runtimeErrorf(listPattern.Pos, "list pattern expects %v items, got %v", len(listPattern.Items), len(listValue.val))
+		}
+		for i, item := range listPattern.Items {
+			if err := item.bind(*listValue.val[i], frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if dictPattern := pattern.Dict; dictPattern != nil {
+		dictValue, okDict := value.(DictValue)
+		if !okDict {
+			return patternTypeError(dictPattern.Pos, "dict", value)
+		}
+		for _, entry := range dictPattern.Entries {
+			entryValue, ok := dictValue.val[entry.Key]
+			if !ok {
+				return runtimeErrorf(entry.Pos, "dict pattern expects key '%v'", entry.Key)
+			}
+			target := entry.Value
+			if target == nil {
+				target = &Pattern{Pos: entry.Pos, Ident: &entry.Key}
+			}
+			if err := target.bind(*entryValue, frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	panic("unreachable Pattern bind")
+}
+
+func patternTypeError(pos lexer.Position, kind string, value Value) error {
+	valueType, err := golfcartType(nil, []Value{value})
+	if err != nil {
+		return err
+	}
+	return runtimeErrorf(pos, "%v pattern can't destructure a value of type %v", kind, valueType)
+}
+
 func (assignment Assignment) String() string {
 	return "assignment"
 }
@@ -420,7 +995,7 @@ func (assignment Assignment) Equals(other Value) (bool, error) {
 }
 
 func (assignment Assignment) Eval(frame *StackFrame) (Value, error) {
-	left, err := assignment.LogicAnd.Eval(frame)
+	left, err := assignment.LogicOr.Eval(frame)
 	if err != nil {
 		return nil, err
 	}
@@ -451,9 +1026,43 @@ func (assignment Assignment) Eval(frame *StackFrame) (Value, error) {
 			frame.Set(leftId.val, right)
 			return right, nil
 		}
-		return nil, fmt.Errorf("%v can't assign to non-identifier: %v", assignment.Pos, left)
+		return nil, runtimeErrorf(assignment.Pos, "can't assign to non-identifier: %v", left)
+	}
+
+	// Compound assignment, the target was evaluated once above
+	right, err = unwrap(right, frame)
+	if err != nil {
+		return nil, err
+	}
+	var current Value
+	if leftRefOk {
+		current = *leftRef.val
+	} else if leftId, okId := left.(IdentifierValue); okId {
+		current, err = frame.Get(leftId.val)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, runtimeErrorf(assignment.Pos, "can't assign to non-identifier: %v", left)
+	}
+	var result Value
+	switch op := assignment.Op[:1]; op {
+	case "+", "-":
+		result, err = addValues(assignment.Pos, op, unref(current), right, frame)
+	case "*", "/", "%":
+		result, err = multiplyValues(assignment.Pos, op, unref(current), right, frame)
+	default:
+		panic("unreachable Assignment Eval")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if leftRefOk {
+		*leftRef.val = result
+	} else {
+		frame.Set(left.(IdentifierValue).val, result)
 	}
-	panic("unreachable Assignment Eval")
+	return result, nil
 }
 
 func (logicAnd LogicAnd) String() string {
@@ -464,42 +1073,38 @@ func (logicAnd LogicAnd) Equals(other Value) (bool, error) {
 	return false, nil
 }
 
+// 'and' and 'or' short-circuit, the right operand is only evaluated (and
+// type checked) when the left one doesn't decide the result.
 func (logicAnd LogicAnd) Eval(frame *StackFrame) (Value, error) {
-	left, err := logicAnd.LogicOr.Eval(frame)
+	left, err := logicAnd.Equality.Eval(frame)
 	if err != nil {
 		return nil, err
 	}
-	if logicAnd.Op == "" {
+	if len(logicAnd.Ops) == 0 {
 		return left, nil
 	}
-	right, err := logicAnd.Next.Eval(frame)
-	if err != nil {
-		return nil, err
-	}
 	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	right, err = unwrap(right, frame)
-	if err != nil {
-		return nil, err
-	}
-
-	if boolValue, okBool := left.(BoolValue); okBool {
-		if boolValue.val {
-			if boolValue, okBool := right.(BoolValue); okBool {
-				if boolValue.val {
-					return boolValue, nil
-				}
-			} else {
-				return nil, fmt.Errorf("%v only bools can be compared with 'and', not: %v", logicAnd.Pos, right)
-			}
+	for _, op := range logicAnd.Ops {
+		leftBool, err := logicOperand(logicAnd.Pos, op.Op, left)
+		if err != nil {
+			return nil, err
+		}
+		if !leftBool.val {
+			return leftBool, nil
+		}
+		left, err = op.Equality.Eval(frame)
+		if err != nil {
+			return nil, err
+		}
+		left, err = unwrap(left, frame)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		return nil, fmt.Errorf("%v only bools can be compared with 'and', not: %v", logicAnd.Pos, left)
 	}
-
-	return BoolValue{val: false}, nil
+	return logicOperand(logicAnd.Pos, logicAnd.Ops[len(logicAnd.Ops)-1].Op, left)
 }
 
 func (logicOr LogicOr) String() string {
@@ -511,41 +1116,43 @@ func (logicOr LogicOr) Equals(other Value) (bool, error) {
 }
 
 func (logicOr LogicOr) Eval(frame *StackFrame) (Value, error) {
-	left, err := logicOr.Equality.Eval(frame)
+	left, err := logicOr.LogicAnd.Eval(frame)
 	if err != nil {
 		return nil, err
 	}
-	if logicOr.Op == "" {
+	if len(logicOr.Ops) == 0 {
 		return left, nil
 	}
-	right, err := logicOr.Next.Eval(frame)
-	if err != nil {
-		return nil, err
-	}
 	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	right, err = unwrap(right, frame)
-	if err != nil {
-		return nil, err
-	}
-
-	if boolValue, okBool := left.(BoolValue); okBool {
-		if boolValue.val {
-			return boolValue, nil
+	for _, op := range logicOr.Ops {
+		leftBool, err := logicOperand(logicOr.Pos, op.Op, left)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		return nil, fmt.Errorf("%v only bools can be compared with 'or', not: %v", logicOr.Pos, left)
-	}
-	if boolValue, okBool := right.(BoolValue); okBool {
-		if boolValue.val {
-			return boolValue, nil
+		if leftBool.val {
+			return leftBool, nil
 		}
-	} else {
-		return nil, fmt.Errorf("%v only bools can be compared with 'or', not: %v", logicOr.Pos, right)
+		left, err = op.LogicAnd.Eval(frame)
+		if err != nil {
+			return nil, err
+		}
+		left, err = unwrap(left, frame)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return logicOperand(logicOr.Pos, logicOr.Ops[len(logicOr.Ops)-1].Op, left)
+}
+
+func logicOperand(pos lexer.Position, op string, value Value) (BoolValue, error) {
+	boolValue, okBool := value.(BoolValue)
+	if !okBool {
+		return BoolValue{}, runtimeErrorf(pos, "only bools can be compared with '%v', not: %v", op, value)
 	}
-	return BoolValue{val: false}, nil
+	return boolValue, nil
 }
 
 func (equality Equality) String() string {
@@ -561,41 +1168,29 @@ func (equality Equality) Eval(frame *StackFrame) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	if equality.Op == "" {
+	if len(equality.Ops) == 0 {
 		return left, nil
 	}
-	right, err := equality.Next.Eval(frame)
+	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	left = unref(left)
-	right = unref(right)
-
-	if idValue, okId := left.(IdentifierValue); okId {
-		value, err := frame.Get(idValue.val)
+	for _, op := range equality.Ops {
+		right, err := op.Comparison.Eval(frame)
 		if err != nil {
 			return nil, err
 		}
-		left = value
-	}
-	if idValue, okId := right.(IdentifierValue); okId {
-		value, err := frame.Get(idValue.val)
+		right, err = unwrap(right, frame)
 		if err != nil {
 			return nil, err
 		}
-		right = value
-	}
-
-	result, err := left.Equals(right)
-	if err != nil {
-		return nil, err
-	}
-	if equality.Op == "==" {
-		return BoolValue{val: result}, nil
-	} else if equality.Op == "!=" {
-		return BoolValue{val: !result}, nil
+		result, err := left.Equals(right)
+		if err != nil {
+			return nil, err
+		}
+		left = BoolValue{val: result == (op.Op == "==")}
 	}
-	panic("unreachable Equality Eval")
+	return left, nil
 }
 
 func (comparison Comparison) String() string {
@@ -611,40 +1206,84 @@ func (comparison Comparison) Eval(frame *StackFrame) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	if comparison.Op == "" {
+	if len(comparison.Ops) == 0 {
 		return left, nil
 	}
-	right, err := comparison.Next.Eval(frame)
-	if err != nil {
-		return nil, err
-	}
-
 	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	right, err = unwrap(right, frame)
-	if err != nil {
-		return nil, err
+	for _, op := range comparison.Ops {
+		right, err := op.Addition.Eval(frame)
+		if err != nil {
+			return nil, err
+		}
+		right, err = unwrap(right, frame)
+		if err != nil {
+			return nil, err
+		}
+		cmp, ordered, err := compareValues(comparison.Pos, op.Op, left, right, make(map[[2]uintptr]bool))
+		if err != nil {
+			return nil, err
+		}
+		left = BoolValue{val: ordered && (op.Op == "<" && cmp < 0 ||
+			op.Op == "<=" && cmp <= 0 ||
+			op.Op == ">" && cmp > 0 ||
+			op.Op == ">=" && cmp >= 0)}
 	}
+	return left, nil
+}
 
-	if leftNum, okNum := left.(NumberValue); okNum {
+// compareValues orders numbers, strings (by code point) and lists (item by
+// item, then by length). ordered is false if a NaN is involved, which makes
+// every comparison false. Like deepEquals, a pair of lists that is already
+// being compared counts as equal, so cyclic lists can be ordered.
+func compareValues(pos lexer.Position, op string, left Value, right Value, seen map[[2]uintptr]bool) (int, bool, error) {
+	left, right = unref(left), unref(right)
+	switch left := left.(type) {
+	case NumberValue:
 		if rightNum, okNum := right.(NumberValue); okNum {
-			return BoolValue{val: comparison.Op == "<" && leftNum.val < rightNum.val ||
-				comparison.Op == "<=" && leftNum.val <= rightNum.val ||
-				comparison.Op == ">" && leftNum.val > rightNum.val ||
-				comparison.Op == ">=" && leftNum.val >= rightNum.val}, nil
+			if math.IsNaN(left.val) || math.IsNaN(rightNum.val) {
+				return 0, false, nil
+			}
+			return numberCompare(left, rightNum), true, nil
+		}
+	case StringValue:
+		if rightStr, okStr := right.(StringValue); okStr {
+			// UTF-8 byte order is code point order
+			return bytes.Compare(left.val, rightStr.val), true, nil
+		}
+	case ListValue:
+		if rightList, okList := right.(ListValue); okList {
+			pair := [2]uintptr{reflect.ValueOf(left.val).Pointer(), reflect.ValueOf(rightList.val).Pointer()}
+			if pair[0] == pair[1] || seen[pair] {
+				return 0, true, nil
+			}
+			seen[pair] = true
+			for i := 0; i < len(left.val) && i < len(rightList.val); i++ {
+				cmp, ordered, err := compareValues(pos, op, *left.val[i], *rightList.val[i], seen)
+				if err != nil || !ordered || cmp != 0 {
+					return cmp, ordered, err
+				}
+			}
+			switch {
+			case len(left.val) < len(rightList.val):
+				return -1, true, nil
+			case len(left.val) > len(rightList.val):
+				return 1, true, nil
+			}
+			return 0, true, nil
 		}
 	}
-	leftType, err := golfcartType([]Value{left})
+	leftType, err := golfcartType(nil, []Value{left})
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
-	rightType, err := golfcartType([]Value{right})
+	rightType, err := golfcartType(nil, []Value{right})
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
-	return nil, fmt.Errorf("%v only numbers can be compared: %v %v %v", comparison.Pos, leftType, comparison.Op, rightType)
+	return 0, false, runtimeErrorf(pos, "only numbers, strings and lists of them can be compared: %v %v %v", leftType, op, rightType)
 }
 
 func (addition Addition) String() string {
@@ -660,39 +1299,54 @@ func (addition Addition) Eval(frame *StackFrame) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	if addition.Op == "" {
+	if len(addition.Ops) == 0 {
 		return left, nil
 	}
-	right, err := addition.Next.Eval(frame)
-	if err != nil {
-		return nil, err
-	}
-
 	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	right, err = unwrap(right, frame)
-	if err != nil {
-		return nil, err
+	for _, op := range addition.Ops {
+		right, err := op.Multiplication.Eval(frame)
+		if err != nil {
+			return nil, err
+		}
+		right, err = unwrap(right, frame)
+		if err != nil {
+			return nil, err
+		}
+		left, err = addValues(addition.Pos, op.Op, left, right, frame)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return left, nil
+}
 
-	err_msg := fmt.Errorf("%v '+' can only be used between [string, string], [number, number], [list, list], not: [%v, %v]",
-		addition.Multiplication.Pos, left, right)
+// addValues applies '+' or '-' to two evaluated operands.
+func addValues(pos lexer.Position, op string, left Value, right Value, frame *StackFrame) (Value, error) {
+	err_msg := runtimeErrorf(pos, "'+' can only be used between [string, string], [number, number], [list, list], not: [%v, %v]",
+		left, right)
 
 	leftStr, okLeft := left.(StringValue)
 	rightStr, okRight := right.(StringValue)
-	if addition.Op == "+" && (okLeft && !okRight || okRight && !okLeft) {
+	if op == "+" && (okLeft && !okRight || okRight && !okLeft) {
 		return nil, err_msg
-	} else if addition.Op == "+" && okLeft && okRight {
+	} else if op == "+" && okLeft && okRight {
+		if err := frame.context.alloc(pos, len(leftStr.val)+len(rightStr.val)); err != nil {
+			return nil, err
+		}
 		return StringValue{val: append([]byte{}, append(leftStr.val, rightStr.val...)...)}, nil
 	}
 
 	leftList, okLeft := left.(ListValue)
 	rightList, okRight := right.(ListValue)
-	if addition.Op == "+" && (okLeft && !okRight || okRight && !okLeft) {
+	if op == "+" && (okLeft && !okRight || okRight && !okLeft) {
 		return nil, err_msg
-	} else if addition.Op == "+" && okLeft && okRight {
+	} else if op == "+" && okLeft && okRight {
+		if err := frame.context.alloc(pos, (len(leftList.val)+len(rightList.val))*approxValueBytes); err != nil {
+			return nil, err
+		}
 		newMap := ListValue{val: map[int]*Value{}}
 		for i, value := range leftList.val {
 			newMap.val[i] = value
@@ -706,14 +1360,12 @@ func (addition Addition) Eval(frame *StackFrame) (Value, error) {
 
 	leftNum, okLeft := left.(NumberValue)
 	rightNum, okRight := right.(NumberValue)
-	if addition.Op == "+" && (okLeft && !okRight || okRight && !okLeft) {
+	if op == "+" && (okLeft && !okRight || okRight && !okLeft) {
 		return nil, err_msg
-	} else if addition.Op == "+" && okLeft && okRight {
-		return NumberValue{val: leftNum.val + rightNum.val}, nil
-	} else if addition.Op == "-" && okLeft && okRight {
-		return NumberValue{val: leftNum.val - rightNum.val}, nil
+	} else if okLeft && okRight {
+		return numberArith(frame.context, pos, op, leftNum, rightNum)
 	}
-	panic("unreachable Addition Eval")
+	return nil, runtimeErrorf(pos, "'-' only supported between numbers")
 }
 
 func (multiplication Multiplication) String() string {
@@ -729,41 +1381,41 @@ func (multiplication Multiplication) Eval(frame *StackFrame) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	if multiplication.Op == "" {
+	if len(multiplication.Ops) == 0 {
 		return left, nil
 	}
-	right, err := multiplication.Next.Eval(frame)
-	if err != nil {
-		return nil, err
-	}
-
 	left, err = unwrap(left, frame)
 	if err != nil {
 		return nil, err
 	}
-	right, err = unwrap(right, frame)
-	if err != nil {
-		return nil, err
+	for _, op := range multiplication.Ops {
+		right, err := op.Unary.Eval(frame)
+		if err != nil {
+			return nil, err
+		}
+		right, err = unwrap(right, frame)
+		if err != nil {
+			return nil, err
+		}
+		left, err = multiplyValues(multiplication.Pos, op.Op, left, right, frame)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return left, nil
+}
 
+// multiplyValues applies '*', '/' or '%' to two evaluated operands.
+func multiplyValues(pos lexer.Position, op string, left Value, right Value, frame *StackFrame) (Value, error) {
 	leftNum, okLeft := left.(NumberValue)
 	if !okLeft {
-		return nil, fmt.Errorf("%v '*' and '/' only supported between numbers", multiplication.Unary.Pos)
+		return nil, runtimeErrorf(pos, "'*' and '/' only supported between numbers")
 	}
 	rightNum, okRight := right.(NumberValue)
 	if !okRight {
-		return nil, fmt.Errorf("%v '*' and '/' only supported between numbers", multiplication.Unary.Pos)
-	}
-	if multiplication.Op == "*" {
-		return NumberValue{val: leftNum.val * rightNum.val}, nil
+		return nil, runtimeErrorf(pos, "'*' and '/' only supported between numbers")
 	}
-	if multiplication.Op == "/" {
-		return NumberValue{val: leftNum.val / rightNum.val}, nil
-	}
-	if multiplication.Op == "%" {
-		return NumberValue{val: float64(int(math.Round(leftNum.val)) % int(math.Round(rightNum.val)))}, nil
-	}
-	panic("unreachable Multiplication Eval")
+	return numberArith(frame.context, pos, op, leftNum, rightNum)
 }
 
 func (unary Unary) String() string {
@@ -787,7 +1439,7 @@ func (unary Unary) Eval(frame *StackFrame) (Value, error) {
 		if boolValue, ok := value.(BoolValue); ok {
 			return BoolValue{val: !boolValue.val}, nil
 		}
-		return nil, fmt.Errorf("%v expected bool after '!'", unary.Pos)
+		return nil, runtimeErrorf(unary.Pos, "expected bool after '!'")
 	}
 	if unary.Op == "-" {
 		value, err := unary.Unary.Eval(frame)
@@ -799,9 +1451,9 @@ func (unary Unary) Eval(frame *StackFrame) (Value, error) {
 			return nil, err
 		}
 		if numberValue, ok := value.(NumberValue); ok {
-			return NumberValue{val: -numberValue.val}, nil
+			return numberNeg(frame.context, unary.Pos, numberValue)
 		}
-		return nil, fmt.Errorf("%v expected number after '-'", unary.Pos)
+		return nil, runtimeErrorf(unary.Pos, "expected number after '-'")
 	}
 	return unary.Primary.Eval(frame)
 }
@@ -814,6 +1466,9 @@ func (primary Primary) Eval(frame *StackFrame) (Value, error) {
 	if ifExpression := primary.If; ifExpression != nil {
 		return ifExpression.Eval(frame)
 	}
+	if try := primary.Try; try != nil {
+		return try.Eval(frame)
+	}
 	if primary.DataLiteral != nil {
 		if functionLiteral := primary.DataLiteral.FunctionLiteral; functionLiteral != nil {
 			return functionLiteral.Eval(frame)
@@ -849,6 +1504,7 @@ func (primary Primary) Eval(frame *StackFrame) (Value, error) {
 	}
 	if forWhileExpression := primary.ForWhile; forWhileExpression != nil {
 		forExpression := For{
+			Pos:       forWhileExpression.Pos,
 			Condition: forWhileExpression.Condition,
 			Body:      forWhileExpression.Body,
 		}
@@ -858,28 +1514,27 @@ func (primary Primary) Eval(frame *StackFrame) (Value, error) {
 		value := forKeyExpression.Value
 		collection := forKeyExpression.Collection
 		collectionExpression := forKeyExpression.CollectionExpression
-		return evalForKeyValue(nil, value, collection, collectionExpression, forKeyExpression.Body, frame)
+		return evalForKeyValue(forKeyExpression.Pos, nil, value, collection, collectionExpression, forKeyExpression.Body, frame)
 	}
 	if forKeyExpression := primary.ForKeyValue; forKeyExpression != nil {
 		key := forKeyExpression.Key
 		value := forKeyExpression.Value
 		collection := forKeyExpression.Collection
 		collectionExpression := forKeyExpression.CollectionExpression
-		return evalForKeyValue(key, value, collection, collectionExpression, forKeyExpression.Body, frame)
+		return evalForKeyValue(forKeyExpression.Pos, key, value, collection, collectionExpression, forKeyExpression.Body, frame)
 	}
 	if primary.Number != nil {
 		return NumberValue{val: *primary.Number}, nil
 	}
+	if primary.Int != nil {
+		return primary.Int.value, nil
+	}
 	if ident := primary.Ident; ident != nil {
 		identifierValue := IdentifierValue{val: *ident}
 		return identifierValue, nil
 	}
-	if primary.Number != nil {
-		return NumberValue{val: *primary.Number}, nil
-	}
 	if primary.Str != nil {
-		// TODO: Parse strings without including quote `"` marks
-		return StringValue{val: []byte(*primary.Str)[1 : len((*primary.Str))-1]}, nil
+		return StringValue{val: []byte(*primary.Str)}, nil
 	}
 	if primary.True != nil {
 		return BoolValue{val: true}, nil
@@ -945,8 +1600,7 @@ func (ifExpression If) Eval(frame *StackFrame) (Value, error) {
 						return result, nil
 					}
 				} else {
-					return nil, fmt.Errorf("%v if expression conditional should evaluate to true or false",
-						ifExpression.Pos)
+					return nil, runtimeErrorf(ifExpression.Pos, "if expression conditional should evaluate to true or false")
 				}
 				current = current.Next
 			}
@@ -961,12 +1615,63 @@ func (ifExpression If) Eval(frame *StackFrame) (Value, error) {
 			return result, nil
 		}
 	} else {
-		return nil, fmt.Errorf("%v if expression conditional should evaluate to true or false",
-			ifExpression.Pos)
+		return nil, runtimeErrorf(ifExpression.Pos, "if expression conditional should evaluate to true or false")
 	}
 	return result, nil
 }
 
+func (try Try) String() string {
+	return "try expression"
+}
+
+func (try Try) Equals(other Value) (bool, error) {
+	return false, nil
+}
+
+func (try Try) Eval(frame *StackFrame) (Value, error) {
+	tryFrame := frame.GetChild()
+	var result Value
+	result = NilValue{}
+	var err error
+	for _, expr := range try.TryBody {
+		result, err = (*expr).Eval(tryFrame)
+		if err != nil {
+			errorValue, okCatch := catchError(err, expr.Pos)
+			if !okCatch {
+				return nil, err
+			}
+			// The caught error is always local to the catch block
+			catchFrame := frame.GetChild()
+			catchFrame.entries[try.Ident] = errorValue
+			result = NilValue{}
+			for _, expr := range try.CatchBody {
+				result, err = (*expr).Eval(catchFrame)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// catchError converts err into the value a catch block receives. Control
+// flow (return, break, continue), cancellation and limits can't be caught.
+// The error keeps the position it was raised at, and pos is only used for
+// errors that don't have one.
+func catchError(err error, pos lexer.Position) (ErrorValue, bool) {
+	switch err := err.(type) {
+	case ReturnValue, BreakValue, ContinueValue, CancelledError, LimitError:
+		return ErrorValue{}, false
+	case ErrorValue:
+		return err, true
+	case RuntimeError:
+		return ErrorValue{message: err.Err.Error(), kind: "runtime", pos: err.Pos, value: NilValue{}}, true
+	}
+	return ErrorValue{message: err.Error(), kind: "runtime", pos: pos, value: NilValue{}}, true
+}
+
 func (functionLiteral FunctionLiteral) String() string {
 	return "functionLiteral"
 }
@@ -977,7 +1682,10 @@ func (functionLiteral FunctionLiteral) Equals(other Value) (bool, error) {
 
 func (functionLiteral FunctionLiteral) Eval(frame *StackFrame) (Value, error) {
 	closureFrame := frame.GetChild()
-	functionValue := FunctionValue{parameters: functionLiteral.Parameters, frame: closureFrame, expressions: functionLiteral.Body}
+	functionValue := FunctionValue{pos: functionLiteral.Pos, parameters: functionLiteral.Parameters, frame: closureFrame, expressions: functionLiteral.Body}
+	if functionLiteral.Rest != nil {
+		functionValue.rest = *functionLiteral.Rest
+	}
 	return functionValue, nil
 }
 
@@ -1011,8 +1719,10 @@ func (dictLiteral DictLiteral) Eval(frame *StackFrame) (Value, error) {
 				return nil, err
 			}
 			if key == "" {
-				return nil, fmt.Errorf("%v can't set empty string as dict key – did you forget to wrap a number with \"\" quote marks?",
-					dictLiteral.Pos)
+				return nil, runtimeErrorf(dictLiteral.Pos, "can't set empty string as dict key – did you forget to wrap a number with \"\" quote marks?")
+			}
+			if err := frame.context.alloc(dictEntry.Pos, len(key)+approxValueBytes); err != nil {
+				return nil, err
 			}
 			dictValue.Set(key, value)
 		}
@@ -1031,13 +1741,25 @@ func (listLiteral ListLiteral) Equals(other Value) (bool, error) {
 
 func (listLiteral ListLiteral) Eval(frame *StackFrame) (Value, error) {
 	values := make(map[int]*Value, 0)
-	if listLiteral.Expressions != nil {
-		for _, expression := range *listLiteral.Expressions {
-			result, err := expression.Eval(frame)
+	if listLiteral.Items != nil {
+		for _, item := range *listLiteral.Items {
+			result, err := item.Value.Eval(frame)
 			if err != nil {
 				return nil, err
 			}
-			values[len(values)] = &result
+			results := []Value{result}
+			if item.Spread {
+				results, err = spreadList(item.Pos, result)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := frame.context.alloc(item.Pos, len(results)*approxValueBytes); err != nil {
+				return nil, err
+			}
+			for i := range results {
+				values[len(values)] = &results[i]
+			}
 		}
 	}
 	return ListValue{val: values}, nil
@@ -1052,7 +1774,14 @@ func (call Call) Equals(other Value) (bool, error) {
 }
 
 func (call Call) Eval(frame *StackFrame) (Value, error) {
-	// TODO: pass the cursor location (call.Pos) for better errors?
+	value, err := call.eval(frame)
+	if err != nil {
+		return nil, atPos(call.Pos, err)
+	}
+	return bindNative(value, frame.context, call.Pos), nil
+}
+
+func (call Call) eval(frame *StackFrame) (Value, error) {
 	var value Value
 	var err error
 	if ident := call.Ident; ident != nil {
@@ -1072,11 +1801,21 @@ func (call Call) Eval(frame *StackFrame) (Value, error) {
 	for chainCall != nil {
 		value = unref(value)
 		var args []Value
+		var named []namedArg
 		if parameters := chainCall.Parameters; parameters != nil {
-			args, err = parseArgs(chainCall.Parameters, frame)
+			args, named, err = parseArgs(chainCall.Parameters, frame)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if chainCall.Slice != nil {
+			value, err = sliceAccess(call.Pos, value, chainCall, frame)
 			if err != nil {
 				return nil, err
 			}
+			chainCall = chainCall.Next
+			continue
 		}
 
 		var access Value
@@ -1094,9 +1833,9 @@ func (call Call) Eval(frame *StackFrame) (Value, error) {
 			var alteredList Value
 			if idVal, okId := access.(IdentifierValue); okId {
 				if idVal.val == "append" {
-					alteredList, err = listAppend(listValue, chainCall, frame)
+					alteredList, err = listAppend(call.Pos, listValue, chainCall, frame)
 				} else if idVal.val == "prepend" {
-					alteredList, err = listPrepend(listValue, chainCall, frame)
+					alteredList, err = listPrepend(call.Pos, listValue, chainCall, frame)
 				} else if idVal.val == "pop" {
 					if len(listValue.val) == 0 {
 						err = fmt.Errorf("cannot pop() from an empty list")
@@ -1121,27 +1860,26 @@ func (call Call) Eval(frame *StackFrame) (Value, error) {
 			}
 		}
 		if dictValue, okDict := value.(DictValue); okDict && access != nil {
-			value, err = dictAccess(dictValue, access)
+			value, err = dictAccess(call.Pos, dictValue, access, frame)
 			if err != nil {
 				return nil, err
 			}
 		}
-		if functionValue, okFunc := value.(FunctionValue); okFunc {
-			value, err = functionValue.Exec(args)
-			if returnValue, okRet := err.(ReturnValue); okRet {
-				value = returnValue.val
-			} else if err != nil {
+		switch value.(type) {
+		case FunctionValue, NativeFunctionValue:
+			value, err = frame.callFunctionNamed(call.Pos, value, args, named)
+			if err != nil {
 				return nil, err
 			}
 		}
-		if nativeFunctionValue, okNatFunc := value.(NativeFunctionValue); okNatFunc {
-			value, err = nativeFunctionValue.Exec(args)
+		if stringValue, okStr := value.(StringValue); okStr && access != nil {
+			value, err = stringAccess(stringValue, access)
 			if err != nil {
 				return nil, err
 			}
 		}
-		if stringValue, okStr := value.(StringValue); okStr && access != nil {
-			value, err = stringAccess(stringValue, access)
+		if errorValue, okErr := value.(ErrorValue); okErr && access != nil {
+			value, err = errorAccess(errorValue, access)
 			if err != nil {
 				return nil, err
 			}
@@ -1156,76 +1894,314 @@ func (call Call) Eval(frame *StackFrame) (Value, error) {
 	return value, nil
 }
 
-func parseArgs(expressions *[]Expression, frame *StackFrame) ([]Value, error) {
-	args := make([]Value, len(*expressions))
-	for i, parameter := range *expressions {
-		result, err := parameter.Eval(frame)
-		if err != nil {
+// callFunction invokes a user-defined or native function, unwrapping
+// the ReturnValue that `return` uses to leave a function body.
+func (frame *StackFrame) callFunction(pos lexer.Position, value Value, args []Value) (Value, error) {
+	return frame.callFunctionNamed(pos, value, args, nil)
+}
+
+func (frame *StackFrame) callFunctionNamed(pos lexer.Position, value Value, args []Value, named []namedArg) (Value, error) {
+	if err := frame.context.checkCancelled(pos); err != nil {
+		return nil, err
+	}
+	switch function := value.(type) {
+	case FunctionValue:
+		if err := frame.context.enterCall(pos); err != nil {
 			return nil, err
 		}
+		result, err := function.exec(args, named)
+		frame.context.exitCall()
+		if returnValue, okRet := err.(ReturnValue); okRet {
+			return returnValue.val, nil
+		}
+		return result, atPos(pos, err)
+	case NativeFunctionValue:
+		if len(named) > 0 {
+			return nil, runtimeErrorf(named[0].pos, "native functions don't take named arguments, got: '%v'", named[0].name)
+		}
+		result, err := function.Exec(&Execution{Pos: pos, Frame: frame, context: frame.context.rootContext()}, args)
+		return result, atPos(pos, err)
+	}
+	golfType, err := golfcartType(nil, []Value{value})
+	if err != nil {
+		return nil, err
+	}
+	return nil, runtimeErrorf(pos, "only functions can be called, not: %v", golfType)
+}
+
+type namedArg struct {
+	pos   lexer.Position
+	name  string
+	value Value
+}
+
+func parseArgs(arguments *[]Argument, frame *StackFrame) ([]Value, []namedArg, error) {
+	args := make([]Value, 0, len(*arguments))
+	var named []namedArg
+	for _, argument := range *arguments {
+		result, err := argument.Value.Eval(frame)
+		if err != nil {
+			return nil, nil, err
+		}
 		argValue := unref(result)
-		args[i] = argValue
+		if argument.Spread {
+			items, err := spreadList(argument.Pos, argValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, items...)
+			continue
+		}
+		if argument.Name != nil {
+			for _, other := range named {
+				if other.name == *argument.Name {
+					return nil, nil, runtimeErrorf(argument.Pos, "argument '%v' was given more than once", *argument.Name)
+				}
+			}
+			named = append(named, namedArg{pos: argument.Pos, name: *argument.Name, value: argValue})
+			continue
+		}
+		args = append(args, argValue)
 	}
-	return args, nil
+	return args, named, nil
+}
+
+// spreadList returns the items of a list that follows `...`.
+func spreadList(pos lexer.Position, value Value) ([]Value, error) {
+	listValue, okList := unref(value).(ListValue)
+	if !okList {
+		valueType, err := golfcartType(nil, []Value{unref(value)})
+		if err != nil {
+			return nil, err
+		}
+		return nil, runtimeErrorf(pos, "only lists can be spread with '...', not: %v", valueType)
+	}
+	return listValue.Val(), nil
 }
 
 func stringAccess(stringValue StringValue, access Value) (Value, error) {
 	if numValue, okNum := access.(NumberValue); okNum {
-		index := int(numValue.val)
-		if index < 0 || index > len(stringValue.val)-1 {
-			return nil, fmt.Errorf("string access out of bounds: %v", index)
+		index, ok := indexOf(numValue, len(stringValue.val))
+		if !ok {
+			return nil, fmt.Errorf("string access expects a whole number, not: %v", numValue)
+		}
+		offset := codePointOffset(stringValue.val, index)
+		if index < 0 || offset == len(stringValue.val) {
+			return nil, fmt.Errorf("string access out of bounds: %v", numValue)
 		}
-		return StringValue{val: []byte{stringValue.val[index]}}, nil
+		_, size := utf8.DecodeRune(stringValue.val[offset:])
+		return StringValue{val: stringValue.val[offset : offset+size]}, nil
 	}
 
-	value, err := golfcartType([]Value{access})
+	value, err := golfcartType(nil, []Value{access})
 	if err != nil {
 		return nil, err
 	}
 	return nil, fmt.Errorf("string access expects 1 argument of type number, not: %v", value)
 }
 
+// sliceAccess returns a new list or string from x[start:end:step]. Like
+// indexing, negative positions count from the end, but out of range
+// positions are clamped rather than an error.
+func sliceAccess(pos lexer.Position, value Value, chainCall *CallChain, frame *StackFrame) (Value, error) {
+	var length int
+	listValue, okList := value.(ListValue)
+	stringValue, okStr := value.(StringValue)
+	if okList {
+		length = len(listValue.val)
+	} else if okStr {
+		length = utf8.RuneCount(stringValue.val)
+	} else {
+		valueType, err := golfcartType(nil, []Value{value})
+		if err != nil {
+			return nil, err
+		}
+		return nil, runtimeErrorf(pos, "only lists and strings can be sliced, not: %v", valueType)
+	}
+
+	step := 1
+	if chainCall.Slice.Step != nil {
+		var err error
+		// Clamp the step past the length, so it can't clamp to zero
+		step, err = sliceIndex(chainCall.Slice.Step, length+1, frame)
+		if err != nil {
+			return nil, err
+		}
+		if step == 0 {
+			return nil, runtimeErrorf(chainCall.Slice.Pos, "slice step cannot be zero")
+		}
+	}
+
+	// A negative step walks backwards, so the defaults and clamping flip
+	start, end := 0, length
+	low, high := 0, length
+	if step < 0 {
+		start, end = length-1, -1
+		low, high = -1, length-1
+	}
+	clamp := func(expr *Expression, index *int) error {
+		if expr == nil {
+			return nil
+		}
+		i, err := sliceIndex(expr, length, frame)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			i += length
+		}
+		if i < low {
+			i = low
+		} else if i > high {
+			i = high
+		}
+		*index = i
+		return nil
+	}
+	if err := clamp(chainCall.ComputedAccess, &start); err != nil {
+		return nil, err
+	}
+	if err := clamp(chainCall.Slice.End, &end); err != nil {
+		return nil, err
+	}
+
+	if okStr && step == 1 {
+		// A forward slice is one run of bytes, so only its ends are found
+		sliced := make([]byte, 0)
+		if start < end {
+			from := codePointOffset(stringValue.val, start)
+			to := from + codePointOffset(stringValue.val[from:], end-start)
+			sliced = append(sliced, stringValue.val[from:to]...)
+		}
+		if err := frame.context.alloc(pos, len(sliced)); err != nil {
+			return nil, err
+		}
+		return StringValue{val: sliced}, nil
+	}
+
+	indices := make([]int, 0)
+	for i := start; step > 0 && i < end || step < 0 && i > end; i += step {
+		indices = append(indices, i)
+	}
+	if okList {
+		if err := frame.context.alloc(pos, len(indices)*approxValueBytes); err != nil {
+			return nil, err
+		}
+		items := make([]Value, len(indices))
+		for i, index := range indices {
+			items[i] = *listValue.val[index]
+		}
+		return NewList(items), nil
+	}
+	points := codePoints(stringValue.val)
+	sliced := make([]byte, 0)
+	for _, index := range indices {
+		sliced = append(sliced, points[index]...)
+	}
+	if err := frame.context.alloc(pos, len(sliced)); err != nil {
+		return nil, err
+	}
+	return StringValue{val: sliced}, nil
+}
+
+// sliceIndex evaluates a slice position. Positions past either end are
+// clamped to ±length before they're converted to an int.
+func sliceIndex(expr *Expression, length int, frame *StackFrame) (int, error) {
+	value, err := expr.Eval(frame)
+	if err != nil {
+		return 0, err
+	}
+	numValue, okNum := unref(value).(NumberValue)
+	if !okNum {
+		valueType, err := golfcartType(nil, []Value{unref(value)})
+		if err != nil {
+			return 0, err
+		}
+		return 0, runtimeErrorf(expr.Pos, "slice positions must be numbers, not: %v", valueType)
+	}
+	index, ok := indexOf(numValue, length)
+	if !ok {
+		return 0, runtimeErrorf(expr.Pos, "slice positions must be whole numbers, not: %v", numValue)
+	}
+	return index, nil
+}
+
+func errorAccess(errorValue ErrorValue, access Value) (Value, error) {
+	var field string
+	if strValue, okStr := access.(StringValue); okStr {
+		field = string(strValue.val)
+	} else if idValue, okId := access.(IdentifierValue); okId {
+		field = idValue.val
+	}
+	switch field {
+	case "message":
+		return StringValue{val: []byte(errorValue.message)}, nil
+	case "kind":
+		return StringValue{val: []byte(errorValue.kind)}, nil
+	case "pos":
+		return StringValue{val: []byte(errorValue.pos.String())}, nil
+	case "value":
+		return errorValue.value, nil
+	}
+	return nil, fmt.Errorf("error values only have the fields message, kind, pos and value, not: %v", access)
+}
+
 func listAccess(listValue ListValue, access Value) (Value, error) {
 	if numValue, okNum := access.(NumberValue); okNum {
-		index := int(numValue.val)
+		index, ok := indexOf(numValue, len(listValue.val))
+		if !ok {
+			return nil, fmt.Errorf("list access expects a whole number, not: %v", numValue)
+		}
 		if index < 0 || index > len(listValue.val)-1 {
-			return nil, fmt.Errorf("list access out of bounds: %v", index)
+			return nil, fmt.Errorf("list access out of bounds: %v", numValue)
 		}
 		return ReferenceValue{val: listValue.val[index]}, nil
 	}
 
-	value, err := golfcartType([]Value{access})
+	value, err := golfcartType(nil, []Value{access})
 	if err != nil {
 		return nil, err
 	}
 	return nil, fmt.Errorf("list access expects 1 argument of type number, not %v", value)
 }
 
-func listAppend(listValue ListValue, chainCall *CallChain, frame *StackFrame) (Value, error) {
-	if chainCall.Next == nil || chainCall.Next.Parameters == nil || len(*chainCall.Next.Parameters) != 1 {
+func listAppend(pos lexer.Position, listValue ListValue, chainCall *CallChain, frame *StackFrame) (Value, error) {
+	if chainCall.Next == nil || chainCall.Next.Parameters == nil {
 		return nil, fmt.Errorf("append() expects 1 argument")
 	}
-	args, err := parseArgs(chainCall.Next.Parameters, frame)
+	args, named, err := parseArgs(chainCall.Next.Parameters, frame)
 	if err != nil {
 		return nil, err
 	}
+	if len(args) != 1 || len(named) != 0 {
+		return nil, fmt.Errorf("append() expects 1 argument")
+	}
+	if err := frame.context.alloc(pos, approxValueBytes); err != nil {
+		return nil, err
+	}
 	listValue.Append(args[0])
 	return listValue, nil
 }
 
-func listPrepend(listValue ListValue, chainCall *CallChain, frame *StackFrame) (Value, error) {
-	if chainCall.Next == nil || chainCall.Next.Parameters == nil || len(*chainCall.Next.Parameters) != 1 {
+func listPrepend(pos lexer.Position, listValue ListValue, chainCall *CallChain, frame *StackFrame) (Value, error) {
+	if chainCall.Next == nil || chainCall.Next.Parameters == nil {
 		return nil, fmt.Errorf("prepend() expects 1 argument")
 	}
-	args, err := parseArgs(chainCall.Next.Parameters, frame)
+	args, named, err := parseArgs(chainCall.Next.Parameters, frame)
 	if err != nil {
 		return nil, err
 	}
+	if len(args) != 1 || len(named) != 0 {
+		return nil, fmt.Errorf("prepend() expects 1 argument")
+	}
+	if err := frame.context.alloc(pos, approxValueBytes); err != nil {
+		return nil, err
+	}
 	listValue.Prepend(args[0])
 	return listValue, nil
 }
 
-func dictAccess(dictValue DictValue, access Value) (Value, error) {
+func dictAccess(pos lexer.Position, dictValue DictValue, access Value, frame *StackFrame) (Value, error) {
 	var key string
 	if strValue, okStr := access.(StringValue); okStr {
 		key = string(strValue.val)
@@ -1234,20 +2210,25 @@ func dictAccess(dictValue DictValue, access Value) (Value, error) {
 	} else if numValue, okNum := access.(NumberValue); okNum {
 		key = nvToS(numValue)
 	} else {
-		golfType, err := golfcartType([]Value{idValue})
+		golfType, err := golfcartType(nil, []Value{idValue})
 		if err != nil {
 			return nil, err
 		}
 		return nil, fmt.Errorf("Only strings are allowed as dict keys, not: %v", golfType)
 	}
+	if _, ok := dictValue.val[key]; !ok {
+		if err := frame.context.alloc(pos, len(key)+approxValueBytes); err != nil {
+			return nil, err
+		}
+	}
 	var newValue Value
 	newValue = NilValue{}
 	value := dictValue.GetOrSet(key, &newValue)
 	return ReferenceValue{val: value}, nil
 }
 
-func evalForKeyValue(keyIdent *string, valueIdent *string, collectionIdent *string, collectionExpression *Expression, expressions []*Expression, frame *StackFrame) (Value, error) {
-	iterations := NumberValue{val: 0}
+func evalForKeyValue(pos lexer.Position, keyIdent *string, valuePattern *Pattern, collectionIdent *string, collectionExpression *Expression, expressions []*Expression, frame *StackFrame) (Value, error) {
+	iterations := intValue(0)
 	forFrame := frame.GetChild()
 	var values Value
 	var err error
@@ -1262,9 +2243,9 @@ func evalForKeyValue(keyIdent *string, valueIdent *string, collectionIdent *stri
 	iterableValues := make([]Value, 0)
 	iterableKeys := make([]Value, 0)
 	if listValue, okList := values.(ListValue); okList {
-		for k, v := range listValue.val {
-			iterableKeys = append(iterableKeys, NumberValue{val: float64(k)})
-			iterableValues = append(iterableValues, *v)
+		for i := 0; i < len(listValue.val); i++ {
+			iterableKeys = append(iterableKeys, intValue(int64(i)))
+			iterableValues = append(iterableValues, *listValue.val[i])
 		}
 	}
 	if dictVal, okDict := values.(DictValue); okDict {
@@ -1274,19 +2255,24 @@ func evalForKeyValue(keyIdent *string, valueIdent *string, collectionIdent *stri
 		}
 	}
 	if strVal, okStr := values.(StringValue); okStr {
-		for k, v := range strVal.val {
-			iterableKeys = append(iterableKeys, NumberValue{val: float64(k)})
-			iterableValues = append(iterableValues, StringValue{val: []byte{v}})
+		for k, v := range codePoints(strVal.val) {
+			iterableKeys = append(iterableKeys, intValue(int64(k)))
+			iterableValues = append(iterableValues, StringValue{val: v})
 		}
 	}
 
 	for i := 0; i < len(iterableValues); i++ {
-		forFrame.Set(*valueIdent, iterableValues[i])
+		if err := frame.context.checkCancelled(pos); err != nil {
+			return nil, err
+		}
+		if err := valuePattern.bind(iterableValues[i], forFrame); err != nil {
+			return nil, err
+		}
 		if keyIdent != nil {
 			forFrame.Set(*keyIdent, iterableKeys[i])
 		}
 		var err error
-		iterations.val++
+		iterations = intValue(iterations.ival + 1)
 		for _, expr := range expressions {
 			_, err = (*expr).Eval(forFrame)
 			if _, okBreak := err.(BreakValue); okBreak {
@@ -1304,7 +2290,7 @@ func evalForKeyValue(keyIdent *string, valueIdent *string, collectionIdent *stri
 }
 
 func (forExpression For) Eval(frame *StackFrame) (Value, error) {
-	iterations := NumberValue{val: 0}
+	iterations := intValue(0)
 	forFrame := frame.GetChild()
 	if forExpression.Init != nil {
 		for _, assignExpr := range forExpression.Init {
@@ -1315,6 +2301,9 @@ func (forExpression For) Eval(frame *StackFrame) (Value, error) {
 		}
 	}
 	for {
+		if err := frame.context.checkCancelled(forExpression.Pos); err != nil {
+			return nil, err
+		}
 		var condition Value
 		var err error
 		if forExpression.Condition != nil {
@@ -1328,7 +2317,7 @@ func (forExpression For) Eval(frame *StackFrame) (Value, error) {
 		}
 		if boolValue, okBool := condition.(BoolValue); okBool {
 			if boolValue.val {
-				iterations.val++
+				iterations = intValue(iterations.ival + 1)
 				for _, expr := range forExpression.Body {
 					_, err = (*expr).Eval(forFrame)
 					if _, okBreak := err.(BreakValue); okBreak {
@@ -1345,7 +2334,7 @@ func (forExpression For) Eval(frame *StackFrame) (Value, error) {
 				break
 			}
 		} else {
-			valueType, err := golfcartType([]Value{condition})
+			valueType, err := golfcartType(nil, []Value{condition})
 			if err != nil {
 				return nil, err
 			}
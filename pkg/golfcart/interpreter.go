@@ -0,0 +1,93 @@
+package golfcart
+
+import (
+	gocontext "context"
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Interpreter is a Golfcart session for embedding the language in Go programs.
+// Globals persist between calls to Eval.
+type Interpreter struct {
+	context Context
+}
+
+// NewInterpreter creates an interpreter with the runtime natives installed.
+func NewInterpreter() *Interpreter {
+	interpreter := &Interpreter{}
+	interpreter.context.Init()
+	InjectRuntime(&interpreter.context)
+	return interpreter
+}
+
+// NewInterpreterWith creates an interpreter with only the allowed runtime
+// natives installed, see InjectRuntimeWith.
+func NewInterpreterWith(allowed ...string) (*Interpreter, error) {
+	interpreter := &Interpreter{}
+	interpreter.context.Init()
+	if err := InjectRuntimeWith(&interpreter.context, allowed...); err != nil {
+		return nil, err
+	}
+	return interpreter, nil
+}
+
+// Context returns the interpreter's underlying context.
+func (interpreter *Interpreter) Context() *Context {
+	return &interpreter.context
+}
+
+// Eval parses and evaluates source against the interpreter's globals,
+// returning the value of the last expression.
+func (interpreter *Interpreter) Eval(source string) (Value, error) {
+	program, err := Compile(source, "")
+	if err != nil {
+		return nil, err
+	}
+	return interpreter.Run(program)
+}
+
+// Run evaluates a compiled program against the interpreter's globals.
+func (interpreter *Interpreter) Run(program *Program) (Value, error) {
+	return program.Eval(&interpreter.context)
+}
+
+// EvalContext is like Eval but stops with a CancelledError once ctx is
+// cancelled or its deadline passes. The context set with SetContext, if
+// any, is restored afterwards.
+func (interpreter *Interpreter) EvalContext(ctx gocontext.Context, source string) (Value, error) {
+	previous := interpreter.context.ctx
+	interpreter.context.SetContext(ctx)
+	defer interpreter.context.SetContext(previous)
+	return interpreter.Eval(source)
+}
+
+// Call invokes a Golfcart function or native with the given arguments,
+// the same way a call expression would.
+func (interpreter *Interpreter) Call(fn Value, args ...Value) (Value, error) {
+	pos := lexer.Position{}
+	if functionValue, okFunc := fn.(FunctionValue); okFunc {
+		pos = functionValue.pos
+	}
+	return interpreter.context.stackFrame.callFunction(pos, unref(fn), args)
+}
+
+// RegisterNative makes a Go function callable from Golfcart under name.
+// Plain Go funcs can be registered with ToValue and SetGlobal instead.
+func (interpreter *Interpreter) RegisterNative(name string, exec func(*Execution, []Value) (Value, error)) {
+	interpreter.SetGlobal(name, NewNativeFunction(name, exec))
+}
+
+// SetGlobal binds name to value in the global frame.
+func (interpreter *Interpreter) SetGlobal(name string, value Value) {
+	interpreter.context.stackFrame.entries[name] = bindNative(value, &interpreter.context, lexer.Position{})
+}
+
+// GetGlobal looks up name in the global frame.
+func (interpreter *Interpreter) GetGlobal(name string) (Value, error) {
+	value, ok := interpreter.context.stackFrame.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot find global '%v'", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package golfcart
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// approxValueBytes is what each list item or dict entry is charged
+// against MaxAllocBytes, on top of the length of any string data.
+const approxValueBytes = 16
+
+// Limits caps the work a script may do in a Context. A zero field means
+// no limit. Usage accumulates across evaluations until SetLimits is called.
+type Limits struct {
+	// MaxSteps is the number of expressions that may be evaluated.
+	MaxSteps int
+	// MaxCallDepth is how deeply user-defined functions may nest.
+	MaxCallDepth int
+	// MaxAllocBytes is the approximate number of bytes that may be
+	// allocated for strings, list items and dict entries.
+	MaxAllocBytes int
+}
+
+// LimitError is returned when evaluation exceeds one of the Limits set on
+// its Context. Limit names which one: "steps", "call depth" or "alloc bytes".
+type LimitError struct {
+	Pos   lexer.Position
+	Limit string
+	Max   int
+}
+
+func (limitError LimitError) Error() string {
+	return fmt.Sprintf("%v %v limit exceeded, max: %v", limitError.Pos, limitError.Limit, limitError.Max)
+}
+
+// SetLimits applies limits to all later evaluation and resets usage.
+func (context *Context) SetLimits(limits Limits) {
+	context.limits = limits
+	context.steps = 0
+	context.callDepth = 0
+	context.allocBytes = 0
+}
+
+func (context *Context) step(pos lexer.Position) error {
+	context = context.rootContext()
+	context.steps++
+	if max := context.limits.MaxSteps; max > 0 && context.steps > max {
+		return LimitError{Pos: pos, Limit: "steps", Max: max}
+	}
+	return nil
+}
+
+func (context *Context) enterCall(pos lexer.Position) error {
+	context = context.rootContext()
+	context.callDepth++
+	if max := context.limits.MaxCallDepth; max > 0 && context.callDepth > max {
+		context.callDepth--
+		return LimitError{Pos: pos, Limit: "call depth", Max: max}
+	}
+	return nil
+}
+
+func (context *Context) exitCall() {
+	context = context.rootContext()
+	context.callDepth--
+}
+
+func (context *Context) alloc(pos lexer.Position, bytes int) error {
+	context = context.rootContext()
+	context.allocBytes += bytes
+	if max := context.limits.MaxAllocBytes; max > 0 && context.allocBytes > max {
+		return LimitError{Pos: pos, Limit: "alloc bytes", Max: max}
+	}
+	return nil
+}
@@ -0,0 +1,554 @@
+package golfcart
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var valueType = reflect.TypeOf((*Value)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// ToValue converts a Go value into a Golfcart value.
+//
+// Bools, numbers (including big.Int) and strings map to their Golfcart
+// equivalents, slices and arrays become lists, maps with string keys and
+// structs become dicts, nil pointers become nil, and funcs become native
+// functions. Struct fields are keyed by their `golfcart:"name"` tag, or
+// their field name when untagged, and fields tagged `golfcart:"-"` are
+// skipped.
+func ToValue(v interface{}) (Value, error) {
+	if v == nil {
+		return NilValue{}, nil
+	}
+	return toValue(reflect.ValueOf(v), cycleGuard{})
+}
+
+// cycleGuard holds the containers being converted on the current path, so
+// that a cyclic value is reported instead of recursing forever. Shared,
+// acyclic values are fine.
+type cycleGuard map[cycleKey]bool
+
+type cycleKey struct {
+	ptr    uintptr
+	goType reflect.Type
+	len    int
+}
+
+func (guard cycleGuard) enter(key cycleKey) error {
+	if guard[key] {
+		return fmt.Errorf("cannot marshal cyclic value")
+	}
+	guard[key] = true
+	return nil
+}
+
+func (guard cycleGuard) exit(key cycleKey) {
+	delete(guard, key)
+}
+
+// goKey identifies a Go pointer, slice or map.
+func goKey(rv reflect.Value) cycleKey {
+	key := cycleKey{ptr: rv.Pointer(), goType: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		key.len = rv.Len()
+	}
+	return key
+}
+
+// golfcartKey identifies a Golfcart list or dict.
+func golfcartKey(value Value) cycleKey {
+	switch value := value.(type) {
+	case ListValue:
+		return cycleKey{ptr: reflect.ValueOf(value.val).Pointer()}
+	case DictValue:
+		return cycleKey{ptr: reflect.ValueOf(value.val).Pointer()}
+	}
+	return cycleKey{}
+}
+
+func toValue(rv reflect.Value, guard cycleGuard) (Value, error) {
+	if rv.Kind() != reflect.Interface && rv.Kind() != reflect.Ptr && rv.Type().Implements(valueType) {
+		return rv.Interface().(Value), nil
+	}
+	if rv.Type() == bigIntType {
+		n := rv.Interface().(big.Int)
+		return NewBigInt(&n), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return BoolValue{val: rv.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intValue(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := rv.Uint(); n <= math.MaxInt64 {
+			return intValue(int64(n)), nil
+		}
+		return NumberValue{val: float64(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return NumberValue{val: rv.Float()}, nil
+	case reflect.String:
+		return StringValue{val: []byte(rv.String())}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return NilValue{}, nil
+		}
+		if rv.Kind() == reflect.Ptr {
+			if err := guard.enter(goKey(rv)); err != nil {
+				return nil, err
+			}
+			defer guard.exit(goKey(rv))
+		}
+		return toValue(rv.Elem(), guard)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return NilValue{}, nil
+		}
+		if rv.Kind() == reflect.Slice && rv.Len() > 0 {
+			if err := guard.enter(goKey(rv)); err != nil {
+				return nil, err
+			}
+			defer guard.exit(goKey(rv))
+		}
+		listValue := ListValue{val: make(map[int]*Value, rv.Len())}
+		for i := 0; i < rv.Len(); i++ {
+			item, err := toValue(rv.Index(i), guard)
+			if err != nil {
+				return nil, err
+			}
+			listValue.Append(item)
+		}
+		return listValue, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %v to a dict, keys must be strings", rv.Type())
+		}
+		if rv.IsNil() {
+			return NilValue{}, nil
+		}
+		if err := guard.enter(goKey(rv)); err != nil {
+			return nil, err
+		}
+		defer guard.exit(goKey(rv))
+		dictValue := DictValue{val: make(map[string]*Value, rv.Len())}
+		iter := rv.MapRange()
+		for iter.Next() {
+			entry, err := toValue(iter.Value(), guard)
+			if err != nil {
+				return nil, err
+			}
+			dictValue.Set(iter.Key().String(), entry)
+		}
+		return dictValue, nil
+	case reflect.Struct:
+		dictValue := DictValue{val: make(map[string]*Value)}
+		for i := 0; i < rv.NumField(); i++ {
+			key, ok := fieldKey(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			entry, err := toValue(rv.Field(i), guard)
+			if err != nil {
+				return nil, err
+			}
+			dictValue.Set(key, entry)
+		}
+		return dictValue, nil
+	case reflect.Func:
+		if rv.IsNil() {
+			return NilValue{}, nil
+		}
+		return wrapFunc(rv), nil
+	}
+	return nil, fmt.Errorf("cannot convert Go type %v to a Golfcart value", rv.Type())
+}
+
+// FromValue stores a Golfcart value in the Go value pointed to by target,
+// following the same mapping as ToValue. Numbers are only stored in integer
+// types when they are whole and fit. Functions can be stored in Go func
+// variables whose last result is an error, which call back into Golfcart
+// when invoked.
+func FromValue(value Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("FromValue() expects a non-nil pointer, not: %T", target)
+	}
+	return fromValue(value, rv.Elem(), cycleGuard{})
+}
+
+func fromValue(value Value, rv reflect.Value, guard cycleGuard) error {
+	if value == nil {
+		return fmt.Errorf("cannot store a nil Value in %v, use NilValue{} for nil", rv.Type())
+	}
+	value = unref(value)
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		goValue, err := toInterface(value, guard)
+		if err != nil {
+			return err
+		}
+		if goValue != nil {
+			rv.Set(reflect.ValueOf(goValue))
+		} else {
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+	if reflect.TypeOf(value).AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+	if rv.Type() == bigIntType || rv.Type() == reflect.PtrTo(bigIntType) {
+		if numValue, okNum := value.(NumberValue); okNum && numValue.isInt {
+			if rv.Kind() == reflect.Ptr {
+				rv.Set(reflect.ValueOf(numValue.Big()))
+			} else {
+				rv.Set(reflect.ValueOf(numValue.Big()).Elem())
+			}
+			return nil
+		}
+	}
+	if _, okNil := value.(NilValue); okNil {
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if boolValue, okBool := value.(BoolValue); okBool {
+			rv.SetBool(boolValue.val)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if numValue, okNum := value.(NumberValue); okNum && numValue.isInt {
+			if numValue.big != nil || rv.OverflowInt(numValue.ival) {
+				return fmt.Errorf("cannot store %v in %v without losing precision", numValue, rv.Type())
+			}
+			rv.SetInt(numValue.ival)
+			return nil
+		} else if okNum {
+			n := numValue.val
+			if n != math.Trunc(n) || rv.OverflowInt(int64(n)) {
+				return fmt.Errorf("cannot store %v in %v without losing precision", numValue, rv.Type())
+			}
+			rv.SetInt(int64(n))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if numValue, okNum := value.(NumberValue); okNum && numValue.isInt {
+			if numValue.big != nil && numValue.big.IsUint64() && !rv.OverflowUint(numValue.big.Uint64()) {
+				rv.SetUint(numValue.big.Uint64())
+				return nil
+			}
+			if numValue.big != nil || numValue.ival < 0 || rv.OverflowUint(uint64(numValue.ival)) {
+				return fmt.Errorf("cannot store %v in %v without losing precision", numValue, rv.Type())
+			}
+			rv.SetUint(uint64(numValue.ival))
+			return nil
+		} else if okNum {
+			n := numValue.val
+			if n < 0 || n != math.Trunc(n) || rv.OverflowUint(uint64(n)) {
+				return fmt.Errorf("cannot store %v in %v without losing precision", numValue, rv.Type())
+			}
+			rv.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if numValue, okNum := value.(NumberValue); okNum {
+			rv.SetFloat(numValue.val)
+			return nil
+		}
+	case reflect.String:
+		if strValue, okStr := value.(StringValue); okStr {
+			rv.SetString(string(strValue.val))
+			return nil
+		}
+	case reflect.Ptr:
+		elem := reflect.New(rv.Type().Elem())
+		if err := fromValue(value, elem.Elem(), guard); err != nil {
+			return err
+		}
+		rv.Set(elem)
+		return nil
+	case reflect.Slice:
+		if listValue, okList := value.(ListValue); okList {
+			if err := guard.enter(golfcartKey(listValue)); err != nil {
+				return err
+			}
+			defer guard.exit(golfcartKey(listValue))
+			slice := reflect.MakeSlice(rv.Type(), len(listValue.val), len(listValue.val))
+			for i := 0; i < len(listValue.val); i++ {
+				if err := fromValue(*listValue.val[i], slice.Index(i), guard); err != nil {
+					return err
+				}
+			}
+			rv.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if listValue, okList := value.(ListValue); okList {
+			if len(listValue.val) != rv.Len() {
+				return fmt.Errorf("cannot store list of length %v in %v", len(listValue.val), rv.Type())
+			}
+			if err := guard.enter(golfcartKey(listValue)); err != nil {
+				return err
+			}
+			defer guard.exit(golfcartKey(listValue))
+			for i := 0; i < rv.Len(); i++ {
+				if err := fromValue(*listValue.val[i], rv.Index(i), guard); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if dictValue, okDict := value.(DictValue); okDict && rv.Type().Key().Kind() == reflect.String {
+			if err := guard.enter(golfcartKey(dictValue)); err != nil {
+				return err
+			}
+			defer guard.exit(golfcartKey(dictValue))
+			m := reflect.MakeMapWithSize(rv.Type(), len(dictValue.val))
+			for key, entry := range dictValue.val {
+				elem := reflect.New(rv.Type().Elem()).Elem()
+				if err := fromValue(*entry, elem, guard); err != nil {
+					return err
+				}
+				m.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), elem)
+			}
+			rv.Set(m)
+			return nil
+		}
+	case reflect.Func:
+		fnType := rv.Type()
+		if fnType.NumOut() == 0 || fnType.Out(fnType.NumOut()-1) != errorType {
+			return fmt.Errorf("cannot store a function in %v, it must return an error last", fnType)
+		}
+		switch function := value.(type) {
+		case FunctionValue:
+			rv.Set(makeFunc(rv.Type(), func(args []Value) (Value, error) {
+				return function.frame.callFunction(function.pos, function, args)
+			}))
+			return nil
+		case NativeFunctionValue:
+			if function.context == nil {
+				return fmt.Errorf("cannot store native function '%v' that isn't bound to an interpreter", function.name)
+			}
+			rv.Set(makeFunc(rv.Type(), func(args []Value) (Value, error) {
+				return function.context.stackFrame.callFunction(function.Pos, function, args)
+			}))
+			return nil
+		}
+	case reflect.Struct:
+		if dictValue, okDict := value.(DictValue); okDict {
+			if err := guard.enter(golfcartKey(dictValue)); err != nil {
+				return err
+			}
+			defer guard.exit(golfcartKey(dictValue))
+			for i := 0; i < rv.NumField(); i++ {
+				key, ok := fieldKey(rv.Type().Field(i))
+				if !ok {
+					continue
+				}
+				if entry, ok := dictValue.val[key]; ok {
+					if err := fromValue(*entry, rv.Field(i), guard); err != nil {
+						return fmt.Errorf("field '%v': %v", key, err)
+					}
+				}
+			}
+			return nil
+		}
+	}
+
+	golfType, err := golfcartType(nil, []Value{value})
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("cannot store Golfcart %v in Go type %v", golfType, rv.Type())
+}
+
+// toInterface converts a Golfcart value into the closest plain Go value.
+// Integers become int64, or *big.Int if they don't fit, and floats float64. Functions are returned as-is.
+func toInterface(value Value, guard cycleGuard) (interface{}, error) {
+	switch value := unref(value).(type) {
+	case NilValue:
+		return nil, nil
+	case BoolValue:
+		return value.val, nil
+	case NumberValue:
+		if value.big != nil {
+			return value.Big(), nil
+		}
+		if value.isInt {
+			return value.ival, nil
+		}
+		return value.val, nil
+	case StringValue:
+		return string(value.val), nil
+	case ListValue:
+		if err := guard.enter(golfcartKey(value)); err != nil {
+			return nil, err
+		}
+		defer guard.exit(golfcartKey(value))
+		items := make([]interface{}, len(value.val))
+		for i := range items {
+			item, err := toInterface(*value.val[i], guard)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case DictValue:
+		if err := guard.enter(golfcartKey(value)); err != nil {
+			return nil, err
+		}
+		defer guard.exit(golfcartKey(value))
+		entries := make(map[string]interface{}, len(value.val))
+		for key, entry := range value.val {
+			item, err := toInterface(*entry, guard)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = item
+		}
+		return entries, nil
+	}
+	return value, nil
+}
+
+func fieldKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("golfcart")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+// wrapFunc exposes a Go func as a native function. Arguments are converted
+// with FromValue and results with ToValue. A trailing error result is
+// returned as a Golfcart error, and multiple results become a list.
+func wrapFunc(fn reflect.Value) NativeFunctionValue {
+	fnType := fn.Type()
+	name := runtime.FuncForPC(fn.Pointer()).Name()
+	if i := strings.LastIndex(name, "."); i != -1 {
+		name = name[i+1:]
+	}
+	exec := func(execution *Execution, args []Value) (Value, error) {
+		numIn := fnType.NumIn()
+		if fnType.IsVariadic() && len(args) < numIn-1 {
+			return nil, fmt.Errorf("%v() called with incorrect number of arguments, wanted at least: %v, got: %v", name, numIn-1, formatValues(args))
+		}
+		if !fnType.IsVariadic() && len(args) != numIn {
+			return nil, fmt.Errorf("%v() called with incorrect number of arguments, wanted: %v, got: %v", name, numIn, formatValues(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var argType reflect.Type
+			if fnType.IsVariadic() && i >= numIn-1 {
+				argType = fnType.In(numIn - 1).Elem()
+			} else {
+				argType = fnType.In(i)
+			}
+			in[i] = reflect.New(argType).Elem()
+			if err := fromValue(arg, in[i], cycleGuard{}); err != nil {
+				return nil, fmt.Errorf("%v() argument %v: %v", name, i+1, err)
+			}
+		}
+
+		out := fn.Call(in)
+		if n := len(out); n > 0 && fnType.Out(n-1) == errorType {
+			if err, _ := out[n-1].Interface().(error); err != nil {
+				return nil, err
+			}
+			out = out[:n-1]
+		}
+		switch len(out) {
+		case 0:
+			return NilValue{}, nil
+		case 1:
+			return toValue(out[0], cycleGuard{})
+		}
+		if err := execution.context.alloc(execution.Pos, len(out)*approxValueBytes); err != nil {
+			return nil, err
+		}
+		results := ListValue{val: make(map[int]*Value, len(out))}
+		for _, result := range out {
+			value, err := toValue(result, cycleGuard{})
+			if err != nil {
+				return nil, err
+			}
+			results.Append(value)
+		}
+		return results, nil
+	}
+	return NativeFunctionValue{name: name, Exec: exec}
+}
+
+// makeFunc builds a Go func of fnType that converts its arguments with
+// ToValue and hands them to call. The result is converted with FromValue,
+// and a list result is spread across multiple return values. Errors are
+// returned through the trailing error result, which fnType must have.
+func makeFunc(fnType reflect.Type, call func([]Value) (Value, error)) reflect.Value {
+	return reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+		if fnType.IsVariadic() {
+			variadic := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < variadic.Len(); i++ {
+				in = append(in, variadic.Index(i))
+			}
+		}
+		out := make([]reflect.Value, fnType.NumOut())
+		for i := range out {
+			out[i] = reflect.New(fnType.Out(i)).Elem()
+		}
+		results := out[:len(out)-1]
+
+		err := func() error {
+			args := make([]Value, len(in))
+			for i := range in {
+				arg, err := toValue(in[i], cycleGuard{})
+				if err != nil {
+					return err
+				}
+				args[i] = arg
+			}
+			result, err := call(args)
+			if err != nil {
+				return err
+			}
+			if len(results) == 1 {
+				return fromValue(result, results[0], cycleGuard{})
+			}
+			if len(results) > 1 {
+				listValue, okList := unref(result).(ListValue)
+				if !okList || len(listValue.val) != len(results) {
+					return fmt.Errorf("expected a list of %v results, got: %v", len(results), result)
+				}
+				for i := range results {
+					if err := fromValue(*listValue.val[i], results[i], cycleGuard{}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}()
+
+		if err != nil {
+			out[len(out)-1].Set(reflect.ValueOf(&err).Elem())
+		}
+		return out
+	})
+}
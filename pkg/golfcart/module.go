@@ -0,0 +1,149 @@
+package golfcart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// moduleCache is shared by a context and every module it imports, directly
+// or not, so that each file is evaluated once and cycles can be reported.
+type moduleCache struct {
+	exports map[string]Value
+	loading []string
+}
+
+func newModuleCache() *moduleCache {
+	return &moduleCache{exports: make(map[string]Value)}
+}
+
+// golfcartImport evaluates a file once and returns its exports. A relative
+// path is resolved against the directory of the importing file.
+func golfcartImport(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("import() expects 1 argument of type string")
+	}
+	pathValue, okStr := args[0].(StringValue)
+	if !okStr {
+		return nil, fmt.Errorf("import() expects 1 argument of type string")
+	}
+	importer := ""
+	if execution.Pos.Filename != "" {
+		abs, err := filepath.Abs(execution.Pos.Filename)
+		if err != nil {
+			return nil, runtimeErrorf(execution.Pos, "import() cannot resolve '%v': %v", execution.Pos.Filename, err)
+		}
+		importer = abs
+	}
+	path := string(pathValue.val)
+	if !filepath.IsAbs(path) && importer != "" {
+		path = filepath.Join(filepath.Dir(importer), path)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, runtimeErrorf(execution.Pos, "import() cannot resolve '%v': %v", string(pathValue.val), err)
+	}
+	// Only Golfcart files, so that errors can't leak what other files hold
+	if ext := filepath.Ext(path); ext != ".golf" && ext != ".golfcart" {
+		return nil, runtimeErrorf(execution.Pos, "import() can only load .golf and .golfcart files, not: '%v'", path)
+	}
+	return execution.context.importModule(execution.Pos, importer, path)
+}
+
+// rootContext is the context of the program being run, whose limits,
+// cancellation and streams its modules share.
+func (context *Context) rootContext() *Context {
+	if context.root != nil {
+		return context.root
+	}
+	return context
+}
+
+func (context *Context) importModule(pos lexer.Position, importer string, path string) (Value, error) {
+	modules := context.modules
+	if exports, ok := modules.exports[path]; ok {
+		return exports, nil
+	}
+
+	// The program that started the imports isn't a module, so it only
+	// shows up as the importer of the first one
+	chain := modules.loading
+	if len(chain) == 0 && importer != "" {
+		chain = []string{importer}
+	}
+	for i, loading := range chain {
+		if loading == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return nil, runtimeErrorf(pos, "import cycle: %v", strings.Join(cycle, " -> "))
+		}
+	}
+
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, runtimeErrorf(pos, "cannot import: %v", err)
+	}
+	// Parse errors quote the source, which needn't be Golfcart at all
+	program, err := Compile(string(source), path)
+	if err != nil {
+		return nil, runtimeErrorf(pos, "cannot import '%v': not a valid program", path)
+	}
+
+	module := context.moduleContext()
+	previous := modules.loading
+	modules.loading = append(chain, path)
+	_, err = program.Eval(module)
+	modules.loading = previous
+	if err != nil {
+		return nil, err
+	}
+
+	exports, err := module.exports(pos)
+	if err != nil {
+		return nil, err
+	}
+	modules.exports[path] = exports
+	return exports, nil
+}
+
+// moduleContext creates a context for a module to be evaluated in, with
+// the runtime natives the importer was granted. Natives the host
+// registered itself aren't passed on.
+func (context *Context) moduleContext() *Context {
+	module := &Context{}
+	module.Init()
+	module.modules = context.modules
+	module.root = context.rootContext()
+	InjectRuntimeWith(module, context.runtime...)
+	return module
+}
+
+// exports is the module's `exports` dict if it defines one, otherwise a
+// dict of its top-level bindings apart from the runtime natives.
+func (context *Context) exports(pos lexer.Position) (Value, error) {
+	entries := context.stackFrame.entries
+	if exports, ok := entries["exports"]; ok {
+		dictValue, okDict := unref(exports).(DictValue)
+		if !okDict {
+			exportsType, err := golfcartType(nil, []Value{unref(exports)})
+			if err != nil {
+				return nil, err
+			}
+			return nil, runtimeErrorf(pos, "a module's exports must be a dict, not: %v", exportsType)
+		}
+		return dictValue, nil
+	}
+	bindings := make(map[string]Value)
+	for name, value := range entries {
+		if nativeValue, okNative := value.(NativeFunctionValue); okNative && nativeValue.name == name {
+			continue
+		}
+		if err := context.alloc(pos, len(name)+approxValueBytes); err != nil {
+			return nil, err
+		}
+		bindings[name] = unref(value)
+	}
+	return NewDict(bindings), nil
+}
@@ -0,0 +1,240 @@
+package golfcart
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Numbers come in two kinds. Int literals and operations between integers
+// produce integers, which are exact. Anything involving a float, and `/`,
+// produces a float. `%` floors like div(), so the result has the sign of
+// the right operand and div(a, b) * b + a % b == a.
+//
+// An integer that doesn't fit in an int64 is held in big instead of ival.
+// Results are always normalised back to int64 when they fit, so big is only
+// set for values that need it, and each big result is charged against the
+// context's MaxAllocBytes.
+
+func intValue(n int64) NumberValue {
+	return NumberValue{val: float64(n), isInt: true, ival: n}
+}
+
+func bigValue(n *big.Int) NumberValue {
+	if n.IsInt64() {
+		return intValue(n.Int64())
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return NumberValue{val: f, isInt: true, big: n}
+}
+
+// toBig returns an integer as a *big.Int. The result must not be modified.
+func (numberValue NumberValue) toBig() *big.Int {
+	if numberValue.big != nil {
+		return numberValue.big
+	}
+	return big.NewInt(numberValue.ival)
+}
+
+// chargeBig charges a big integer result by its size in bytes.
+func chargeBig(context *Context, pos lexer.Position, value NumberValue) (NumberValue, error) {
+	if value.big != nil {
+		if err := context.alloc(pos, value.big.BitLen()/8); err != nil {
+			return NumberValue{}, err
+		}
+	}
+	return value, nil
+}
+
+// indexOf converts a number used as a position in something of the given
+// length. Anything past either end, including big integers and huge floats,
+// is clamped to ±length so it can't wrap around when converted to an int.
+// Floats with a fractional part aren't positions, so ok is false for them.
+func indexOf(numberValue NumberValue, length int) (index int, ok bool) {
+	if numberValue.isInt && numberValue.big != nil {
+		if numberValue.big.Sign() < 0 {
+			return -length, true
+		}
+		return length, true
+	}
+	if numberValue.isInt {
+		if numberValue.ival > int64(length) {
+			return length, true
+		}
+		if numberValue.ival < -int64(length) {
+			return -length, true
+		}
+		return int(numberValue.ival), true
+	}
+	n := numberValue.val
+	if n != math.Trunc(n) {
+		return 0, false
+	}
+	if n > float64(length) {
+		return length, true
+	}
+	if n < -float64(length) {
+		return -length, true
+	}
+	return int(n), true
+}
+
+func numberArith(context *Context, pos lexer.Position, op string, left NumberValue, right NumberValue) (NumberValue, error) {
+	if left.isInt && right.isInt && left.big == nil && right.big == nil {
+		a, b := left.ival, right.ival
+		switch op {
+		case "+":
+			if c := a + b; (c > a) == (b > 0) {
+				return intValue(c), nil
+			}
+		case "-":
+			if c := a - b; (c < a) == (b > 0) {
+				return intValue(c), nil
+			}
+		case "*":
+			if a == 0 || b == 0 {
+				return intValue(0), nil
+			}
+			c := a * b
+			if c/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64) {
+				return intValue(c), nil
+			}
+		case "%":
+			if b == 0 {
+				return NumberValue{}, runtimeErrorf(pos, "integer modulo by zero")
+			}
+			if b == -1 {
+				return intValue(0), nil
+			}
+			r := a % b
+			if r != 0 && (r < 0) != (b < 0) {
+				r += b
+			}
+			return intValue(r), nil
+		}
+	}
+
+	// Overflowed, or one side was already big
+	if left.isInt && right.isInt && op != "/" {
+		a, b := left.toBig(), right.toBig()
+		switch op {
+		case "+":
+			return chargeBig(context, pos, bigValue(new(big.Int).Add(a, b)))
+		case "-":
+			return chargeBig(context, pos, bigValue(new(big.Int).Sub(a, b)))
+		case "*":
+			return chargeBig(context, pos, bigValue(new(big.Int).Mul(a, b)))
+		case "%":
+			if b.Sign() == 0 {
+				return NumberValue{}, runtimeErrorf(pos, "integer modulo by zero")
+			}
+			r := new(big.Int).Rem(a, b)
+			if r.Sign() != 0 && (r.Sign() < 0) != (b.Sign() < 0) {
+				r.Add(r, b)
+			}
+			return chargeBig(context, pos, bigValue(r))
+		}
+	}
+
+	a, b := left.val, right.val
+	switch op {
+	case "+":
+		return NumberValue{val: a + b}, nil
+	case "-":
+		return NumberValue{val: a - b}, nil
+	case "*":
+		return NumberValue{val: a * b}, nil
+	case "/":
+		return NumberValue{val: a / b}, nil
+	case "%":
+		r := math.Mod(a, b)
+		if r != 0 && (r < 0) != (b < 0) {
+			r += b
+		}
+		return NumberValue{val: r}, nil
+	}
+	panic("unreachable numberArith")
+}
+
+// numberDiv is floor division. It's exact for two integers.
+func numberDiv(context *Context, pos lexer.Position, left NumberValue, right NumberValue) (NumberValue, error) {
+	if left.isInt && right.isInt {
+		if right.big == nil && right.ival == 0 {
+			return NumberValue{}, runtimeErrorf(pos, "integer division by zero")
+		}
+		if left.big == nil && right.big == nil && !(left.ival == math.MinInt64 && right.ival == -1) {
+			a, b := left.ival, right.ival
+			q := a / b
+			if a%b != 0 && (a < 0) != (b < 0) {
+				q--
+			}
+			return intValue(q), nil
+		}
+		a, b := left.toBig(), right.toBig()
+		q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+		if r.Sign() != 0 && (r.Sign() < 0) != (b.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		}
+		return chargeBig(context, pos, bigValue(q))
+	}
+	return NumberValue{val: math.Floor(left.val / right.val)}, nil
+}
+
+func numberNeg(context *Context, pos lexer.Position, value NumberValue) (NumberValue, error) {
+	if value.isInt {
+		if value.big == nil && value.ival != math.MinInt64 {
+			return intValue(-value.ival), nil
+		}
+		return chargeBig(context, pos, bigValue(new(big.Int).Neg(value.toBig())))
+	}
+	return NumberValue{val: -value.val}, nil
+}
+
+// numberCompare returns -1, 0 or 1 as left is less than, equal to or
+// greater than right.
+func numberCompare(left NumberValue, right NumberValue) int {
+	if left.isInt && right.isInt {
+		if left.big != nil || right.big != nil {
+			return left.toBig().Cmp(right.toBig())
+		}
+		switch {
+		case left.ival < right.ival:
+			return -1
+		case left.ival > right.ival:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case left.val < right.val:
+		return -1
+	case left.val > right.val:
+		return 1
+	}
+	return 0
+}
+
+// parseInt parses a base 10 integer of any size.
+func parseInt(s string) (NumberValue, bool) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return NumberValue{}, false
+	}
+	return bigValue(n), true
+}
+
+// IntLiteral is an Int token, parsed once when the program is compiled.
+type IntLiteral struct {
+	value NumberValue
+}
+
+func (intLiteral *IntLiteral) Capture(values []string) error {
+	value, ok := parseInt(values[0])
+	if !ok {
+		return fmt.Errorf("invalid integer '%v'", values[0])
+	}
+	intLiteral.value = value
+	return nil
+}
@@ -1,6 +1,11 @@
 package golfcart
 
 import (
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/alecthomas/participle/v2/lexer/stateful"
@@ -15,64 +20,144 @@ type ExpressionList struct {
 type Expression struct {
 	Pos lexer.Position
 
-	Assignment          *Assignment `@@`
+	Destructure         *Destructure `  @@`
+	Assignment          *Assignment  `| @@`
 	NativeFunctionValue *NativeFunctionValue
 }
 
+// Destructure assigns the parts of a list or dict to several variables.
+// `a, b = pair` is the same as `[a, b] = pair`.
+type Destructure struct {
+	Pos lexer.Position
+
+	Guard    *destructureGuard `@@`
+	Patterns []*Pattern        `@@ ( "," @@ )* "="`
+	Value    *LogicOr          `@@`
+}
+
+type Pattern struct {
+	Pos lexer.Position
+
+	Ident *string      `  @Ident`
+	List  *ListPattern `| @@`
+	Dict  *DictPattern `| @@`
+}
+
+type ListPattern struct {
+	Pos lexer.Position
+
+	Items []*Pattern `"[" @@ ( "," @@ )* "]"`
+}
+
+type DictPattern struct {
+	Pos lexer.Position
+
+	Entries []*DictPatternEntry `"{" @@ ( "," @@ )* ","? "}"`
+}
+
+// DictPatternEntry binds the value at Key to a variable of the same name,
+// or to Value if it's given, as in `{name: n}`.
+type DictPatternEntry struct {
+	Pos lexer.Position
+
+	Key   string   `@Ident`
+	Value *Pattern `( ":" @@ )?`
+}
+
 type Assignment struct {
 	Pos lexer.Position
 
+	LogicOr *LogicOr `@@`
+	Op      string   `( @( "=" | "+" "=" | "-" "=" | "*" "=" | "/" "=" | "%" "=" )`
+	Next    *LogicOr `  @@ )?`
+}
+
+// Binary operators are parsed as a first operand followed by any number of
+// operator and operand pairs, which are then applied left to right. Each
+// level binds tighter than the one before it.
+
+type LogicOr struct {
+	Pos lexer.Position
+
+	LogicAnd *LogicAnd    `@@`
+	Ops      []*LogicOrOp `@@*`
+}
+
+type LogicOrOp struct {
+	Pos lexer.Position
+
+	Op       string    `@"or"`
 	LogicAnd *LogicAnd `@@`
-	Op       string    `( @"="`
-	Next     *LogicAnd `  @@ )?`
 }
 
 type LogicAnd struct {
 	Pos lexer.Position
 
-	LogicOr *LogicOr  `@@`
-	Op      string    `( @( "and" )`
-	Next    *LogicAnd `  @@ )?`
+	Equality *Equality     `@@`
+	Ops      []*LogicAndOp `@@*`
 }
 
-type LogicOr struct {
+type LogicAndOp struct {
 	Pos lexer.Position
 
+	Op       string    `@"and"`
 	Equality *Equality `@@`
-	Op       string    `( @( "or" )`
-	Next     *LogicOr  `  @@ )?`
 }
 
 type Equality struct {
 	Pos lexer.Position
 
+	Comparison *Comparison   `@@`
+	Ops        []*EqualityOp `@@*`
+}
+
+type EqualityOp struct {
+	Pos lexer.Position
+
+	Op         string      `@( "!" "=" | "=" "=" )`
 	Comparison *Comparison `@@`
-	Op         string      `( @( "!" "=" | "=" "=" )`
-	Next       *Equality   `  @@ )?`
 }
 
 type Comparison struct {
 	Pos lexer.Position
 
-	Addition *Addition   `@@`
-	Op       string      `( @( ">" "=" | ">" | "<" "=" | "<" )`
-	Next     *Comparison `  @@ )?`
+	Addition *Addition       `@@`
+	Ops      []*ComparisonOp `@@*`
+}
+
+type ComparisonOp struct {
+	Pos lexer.Position
+
+	Op       string    `@( ">" "=" | ">" | "<" "=" | "<" )`
+	Addition *Addition `@@`
 }
 
 type Addition struct {
 	Pos lexer.Position
 
 	Multiplication *Multiplication `@@`
-	Op             string          `( @( "-" | "+" )`
-	Next           *Addition       `  @@ )?`
+	Ops            []*AdditionOp   `@@*`
+}
+
+type AdditionOp struct {
+	Pos lexer.Position
+
+	Op             string          `@( "-" | "+" )`
+	Multiplication *Multiplication `@@`
 }
 
 type Multiplication struct {
 	Pos lexer.Position
 
-	Unary *Unary          `@@`
-	Op    string          `( @( "/" | "*" | "%")`
-	Next  *Multiplication `  @@ )?`
+	Unary *Unary              `@@`
+	Ops   []*MultiplicationOp `@@*`
+}
+
+type MultiplicationOp struct {
+	Pos lexer.Position
+
+	Op    string `@( "/" | "*" | "%" )`
+	Unary *Unary `@@`
 }
 
 type Unary struct {
@@ -87,6 +172,7 @@ type Primary struct {
 	Pos lexer.Position
 
 	If            *If          `@@`
+	Try           *Try         `| @@`
 	DataLiteral   *DataLiteral `| @@`
 	SubExpression *Expression  `| "(" @@ ")"`
 	Call          *Call        `| @@`
@@ -98,8 +184,9 @@ type Primary struct {
 	Return      *Return      `| @@`
 	Break       *Break       `| @@`
 	Continue    *Continue    `| @@`
-	Number      *float64     `| @Float | @Int`
-	Str         *string      `| @String`
+	Number      *float64     `| @Float`
+	Int         *IntLiteral  `| @Int`
+	Str         *string      `| @( String | RawString )`
 	True        *bool        `| @"true"`
 	False       *bool        `| @"false"`
 	Nil         *bool        `| @"nil"`
@@ -127,17 +214,63 @@ type ElseIf struct {
 	Next      *ElseIf       `@@*`
 }
 
+type Try struct {
+	Pos lexer.Position
+
+	TryBody   []*Expression `"try" "{" @@* "}"`
+	Ident     string        `"catch" @Ident`
+	CatchBody []*Expression `"{" @@* "}"`
+}
+
 type FunctionLiteral struct {
 	Pos lexer.Position
 
-	Parameters []string      `( "(" ( @Ident ( "," @Ident )* )? ")" | @Ident )`
-	Body       []*Expression `"=" ">" ( "{" @@* "}" | @@ )`
+	Guard      *functionGuard `@@`
+	Parameters []*Parameter   `( @@ | "(" ( @@ ( "," @@ )*`
+	Rest       *string        `( "," "." "." "." @Ident )? | "." "." "." @Ident )? ")" )`
+	Body       []*Expression  `"=" ">" ( "{" @@* "}" | @@ )`
+}
+
+// A Parameter's default is evaluated each time the function is called
+// without a value for it.
+type Parameter struct {
+	Pos lexer.Position
+
+	Name    string   `@Ident`
+	Default *LogicOr `( "=" @@ )?`
 }
 
 type ListLiteral struct {
 	Pos lexer.Position
 
-	Expressions *[]Expression `"[" ( @@ ( "," @@ )* )? "]"`
+	Items *[]ListItem `"[" ( @@ ( "," @@ )* )? "]"`
+}
+
+// A ListItem prefixed with `...` is a list whose items are spread into
+// the new list.
+type ListItem struct {
+	Pos lexer.Position
+
+	Spread bool  `@( "." "." "." )?`
+	Value  *Item `@@`
+}
+
+// An Item is a call argument or list item. Commas separate items, so only
+// a single pattern can be destructured in one: `f(a, b = 2)` passes `a`
+// and `b = 2`, while `f([a, b] = pair)` destructures pair.
+type Item struct {
+	Pos lexer.Position
+
+	Destructure *ItemDestructure `  @@`
+	Assignment  *Assignment      `| @@`
+}
+
+type ItemDestructure struct {
+	Pos lexer.Position
+
+	Guard   *itemGuard `@@`
+	Pattern *Pattern   `@@ "="`
+	Value   *LogicOr   `@@`
 }
 
 type DictLiteral struct {
@@ -162,11 +295,31 @@ type Call struct {
 	CallChain     *CallChain  `@@`
 }
 
+// When Slice is set, ComputedAccess is the slice's start, if it has one.
 type CallChain struct {
-	Parameters     *[]Expression `( "(" ( @@ ( "," @@ )* )? ")" `
-	Access         *string       `    | "." @Ident`
-	ComputedAccess *Expression   `    | "[" @@ "]" )`
-	Next           *CallChain    `@@?`
+	Parameters     *[]Argument `( "(" ( @@ ( "," @@ )* )? ")" `
+	Access         *string     `    | "." @Ident`
+	ComputedAccess *Expression `    | "[" ( @@`
+	Slice          *Slice      `        @@? | @@ ) "]" )`
+	Next           *CallChain  `@@?`
+}
+
+// An Argument prefixed with `...` is a list whose items are passed as
+// separate arguments. One prefixed with `name:` is passed to the
+// parameter with that name.
+type Argument struct {
+	Pos lexer.Position
+
+	Spread bool    `( @( "." "." "." )`
+	Name   *string `| @Ident ":" )?`
+	Value  *Item   `@@`
+}
+
+type Slice struct {
+	Pos lexer.Position
+
+	End  *Expression `":" @@?`
+	Step *Expression `( ":" @@? )?`
 }
 
 type Break struct {
@@ -200,7 +353,8 @@ type For struct {
 type ForValue struct {
 	Pos lexer.Position
 
-	Value                *string       `( "for" @Ident "in"`
+	Guard                *loopGuard    `( "for" @@`
+	Value                *Pattern      `@@ "in"`
 	Collection           *string       `( @Ident`
 	CollectionExpression *Expression   `| @@) `
 	Body                 []*Expression `"{" @@* "}" )`
@@ -210,7 +364,7 @@ type ForKeyValue struct {
 	Pos lexer.Position
 
 	Key                  *string       `( "for" @Ident ","`
-	Value                *string       `@Ident "in"`
+	Value                *Pattern      `@@ "in"`
 	Collection           *string       `( @Ident`
 	CollectionExpression *Expression   `| @@) `
 	Body                 []*Expression `"{" @@* "}" )`
@@ -230,28 +384,224 @@ var (
 		"Root": {
 			{"comment", `//.*|/\*.*?\*/`, nil},
 			{"whitespace", `[\n\r\t ]+`, nil},
-			{"Float", `[+-]?([0-9]*[.])?[0-9]+`, nil},
+			{"Float", `[0-9]*[.][0-9]+`, nil},
 			{"Int", `[\d]+`, nil},
-			{"String", `"([^"]*)"`, nil},
+			{"String", `"(\\.|[^"\\])*"`, nil},
+			{"RawString", "`[^`]*`", nil},
 			{"Ident", `[\w]+`, nil},
 			{"Punct", `[-[!*%()+_={}\|:;"<,>./]|]`, nil},
 		},
 	}))
 	parser = participle.MustBuild(&ExpressionList{}, participle.Lexer(_lexer),
+		participle.Map(unescapeString, "String"), participle.Map(unquoteRawString, "RawString"),
 		participle.Elide("whitespace", "comment"), participle.UseLookahead(2))
 )
 
+// unescapeString strips the quotes from a String token and processes its
+// escapes: \" \\ \n \r \t and \u{...} with a hex code point.
+func unescapeString(token lexer.Token) (lexer.Token, error) {
+	s := token.Value[1 : len(token.Value)-1]
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case '"', '\\':
+			out.WriteByte(s[i])
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'u':
+			end := strings.IndexByte(s[i:], '}')
+			if i+1 >= len(s) || s[i+1] != '{' || end < 0 {
+				return token, participle.Errorf(token.Pos, "invalid unicode escape in string, expected \\u{...}")
+			}
+			hex := s[i+2 : i+end]
+			code, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil || len(hex) > 6 || !utf8.ValidRune(rune(code)) {
+				return token, participle.Errorf(token.Pos, "invalid unicode escape '\\u{%v}' in string", hex)
+			}
+			out.WriteRune(rune(code))
+			i += end
+		default:
+			return token, participle.Errorf(token.Pos, "invalid escape '\\%c' in string", s[i])
+		}
+	}
+	token.Value = out.String()
+	return token, nil
+}
+
+// unquoteRawString strips the backticks from a RawString token. Nothing
+// inside is escaped.
+func unquoteRawString(token lexer.Token) (lexer.Token, error) {
+	token.Value = token.Value[1 : len(token.Value)-1]
+	return token, nil
+}
+
 func GetGrammer() string {
-	return parser.String()
+	grammar := parser.String()
+	for _, guard := range []interface{}{destructureGuard{}, itemGuard{}, loopGuard{}, functionGuard{}} {
+		grammar = strings.ReplaceAll(grammar, reflect.TypeOf(guard).Name()+" ", "")
+	}
+	return grammar
 }
 
 func GenerateAST(source string) (*ExpressionList, error) {
-	expressionList := &ExpressionList{}
-
-	err := parser.ParseString("", source, expressionList)
+	program, err := Compile(source, "")
 	if err != nil {
 		return nil, err
 	}
 
-	return expressionList, nil
+	return program.ast, nil
+}
+
+// The guards below are Parseables that look ahead without consuming
+// anything, and return NextMatch to turn a branch away. They make choices
+// that need more lookahead than the parser uses, and match no input, so
+// GetGrammer leaves them out of the grammar.
+
+// destructureGuard only lets a Destructure be parsed when one or more
+// comma separated patterns are followed by `=` (not `==` or `=>`). A
+// pattern can be any length, so otherwise `[a, b] = pair` would be taken
+// for a list literal, and `a, b = pair` couldn't be told apart from `a`
+// followed by more. A single identifier is left to Assignment, which also
+// handles `a += 1`.
+type destructureGuard struct{}
+
+func (guard *destructureGuard) Parse(lex *lexer.PeekingLexer) error {
+	return scanDestructure(lex.Clone(), true)
+}
+
+// itemGuard does the same for an Item, but only allows a single pattern,
+// as a comma there starts the next argument or list item.
+type itemGuard struct{}
+
+func (guard *itemGuard) Parse(lex *lexer.PeekingLexer) error {
+	return scanDestructure(lex.Clone(), false)
+}
+
+// scanDestructure consumes the patterns of a destructuring assignment up
+// to its `=`, returning NextMatch if they aren't one.
+func scanDestructure(lex *lexer.PeekingLexer, several bool) error {
+	first, _ := lex.Peek(0)
+	if !scanPattern(lex) {
+		return participle.NextMatch
+	}
+	patterns := 1
+	for several && peekPunct(lex, 0, ",") {
+		lex.Next()
+		if !scanPattern(lex) {
+			return participle.NextMatch
+		}
+		patterns++
+	}
+	if first.Type == _lexer.Symbols()["Ident"] && patterns == 1 {
+		return participle.NextMatch
+	}
+	if !peekPunct(lex, 0, "=") || peekPunct(lex, 1, "=") || peekPunct(lex, 1, ">") {
+		return participle.NextMatch
+	}
+	return nil
+}
+
+// loopGuard only lets ForValue be parsed when a pattern is followed by
+// `in`. Otherwise the body of `for { ... }` would be taken for a dict
+// pattern, and `for [a, b] in pairs` for a list literal condition.
+type loopGuard struct{}
+
+func (guard *loopGuard) Parse(lex *lexer.PeekingLexer) error {
+	lex = lex.Clone()
+	if !scanPattern(lex) {
+		return participle.NextMatch
+	}
+	if token, _ := lex.Peek(0); token.Type != _lexer.Symbols()["Ident"] || token.Value != "in" {
+		return participle.NextMatch
+	}
+	return nil
+}
+
+// functionGuard only lets a FunctionLiteral be parsed when an identifier,
+// or a balanced `( ... )`, is followed by `=>`. A parameter with a default
+// looks like an assignment, so `(a = 1) => a` would otherwise be taken for
+// a parenthesised assignment until the `=>` is reached.
+type functionGuard struct{}
+
+func (guard *functionGuard) Parse(lex *lexer.PeekingLexer) error {
+	lex = lex.Clone()
+	if token, _ := lex.Next(); token.Type == _lexer.Symbols()["Ident"] {
+		if peekPunct(lex, 0, "=") && peekPunct(lex, 1, ">") {
+			return nil
+		}
+		return participle.NextMatch
+	} else if token.Type != _lexer.Symbols()["Punct"] || token.Value != "(" {
+		return participle.NextMatch
+	}
+	depth := 1
+	for depth > 0 {
+		token, _ := lex.Next()
+		switch {
+		case token.EOF():
+			return participle.NextMatch
+		case token.Type != _lexer.Symbols()["Punct"]:
+		case token.Value == "(" || token.Value == "[" || token.Value == "{":
+			depth++
+		case token.Value == ")" || token.Value == "]" || token.Value == "}":
+			depth--
+		}
+	}
+	if peekPunct(lex, 0, "=") && peekPunct(lex, 1, ">") {
+		return nil
+	}
+	return participle.NextMatch
+}
+
+// scanPattern consumes one Pattern, reporting whether it was well formed.
+func scanPattern(lex *lexer.PeekingLexer) bool {
+	token, _ := lex.Next()
+	switch {
+	case token.Type == _lexer.Symbols()["Ident"]:
+		return true
+	case token.Type == _lexer.Symbols()["Punct"] && token.Value == "[":
+		for {
+			if !scanPattern(lex) {
+				return false
+			}
+			if token, _ := lex.Next(); token.Value != "," {
+				return token.Value == "]"
+			}
+		}
+	case token.Type == _lexer.Symbols()["Punct"] && token.Value == "{":
+		for {
+			if key, _ := lex.Next(); key.Type != _lexer.Symbols()["Ident"] {
+				return false
+			}
+			if peekPunct(lex, 0, ":") {
+				lex.Next()
+				if !scanPattern(lex) {
+					return false
+				}
+			}
+			token, _ := lex.Next()
+			if token.Value == "," && peekPunct(lex, 0, "}") {
+				token, _ = lex.Next()
+			}
+			if token.Value != "," {
+				return token.Value == "}"
+			}
+		}
+	}
+	return false
+}
+
+// previousToken returns the last token before the cursor that wasn't
+// elided, or an EOF token at the start of the program.
+func peekPunct(lex *lexer.PeekingLexer, n int, value string) bool {
+	token, _ := lex.Peek(n)
+	return token.Type == _lexer.Symbols()["Punct"] && token.Value == value
 }
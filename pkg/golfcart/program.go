@@ -0,0 +1,41 @@
+package golfcart
+
+// Program is a parsed Golfcart program. Evaluation never modifies it, so a
+// Program can be run many times, including concurrently, as long as each
+// run has its own Context.
+type Program struct {
+	filename string
+	ast      *ExpressionList
+}
+
+// Compile parses source once so it can be run many times. The filename is
+// only used in error positions.
+func Compile(source string, filename string) (*Program, error) {
+	ast := &ExpressionList{}
+	err := parser.ParseString(filename, source, ast)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{filename: filename, ast: ast}, nil
+}
+
+// Filename returns the name the program was compiled with.
+func (program *Program) Filename() string {
+	return program.filename
+}
+
+// Eval runs the program against context.
+func (program *Program) Eval(context *Context) (Value, error) {
+	return program.ast.Eval(context)
+}
+
+// Run runs the program in a fresh interpreter with the runtime natives
+// installed and globals bound. Values in globals are shared by reference,
+// so concurrent runs shouldn't be given the same list or dict.
+func (program *Program) Run(globals map[string]Value) (Value, error) {
+	interpreter := NewInterpreter()
+	for name, value := range globals {
+		interpreter.SetGlobal(name, value)
+	}
+	return interpreter.Run(program)
+}
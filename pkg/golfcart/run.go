@@ -1,30 +1,33 @@
 package golfcart
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 )
 
 const VERSION = 0.1
 
 func RunProgram(source string, debug bool) (*string, error) {
-	ast, err := GenerateAST(source)
+	return RunProgramFile(source, "", debug)
+}
+
+// RunProgramFile is like RunProgram for source read from filename, which
+// imports are resolved against and errors are reported with.
+func RunProgramFile(source string, filename string, debug bool) (*string, error) {
+	interpreter, err := NewInterpreterWith(CapabilityCore, CapabilityIO, CapabilityTime, CapabilityImport)
 	if err != nil {
 		return nil, err
 	}
-
-	context := Context{}
-	context.Init()
-	InjectRuntime(&context)
-
-	result, err := ast.Eval(&context)
+	program, err := Compile(source, filename)
+	if err != nil {
+		return nil, err
+	}
+	result, err := interpreter.Run(program)
 	if err != nil {
 		return nil, err
 	}
 
 	if debug {
-		fmt.Println(context.stackFrame.String())
+		fmt.Fprintln(interpreter.context.stdout, interpreter.context.stackFrame.String())
 	}
 
 	ret := result.String()
@@ -32,7 +35,16 @@ func RunProgram(source string, debug bool) (*string, error) {
 }
 
 func REPL() {
-	fmt.Printf(`
+	context := Context{}
+	context.Init()
+	InjectRuntimeWith(&context, CapabilityCore, CapabilityIO, CapabilityTime, CapabilityImport)
+	RunREPL(&context)
+}
+
+// RunREPL runs the language shell against the context's streams
+// until its input runs out.
+func RunREPL(context *Context) {
+	fmt.Fprintf(context.stdout, `
       .-::":-.
     .'''..''..'.
    /..''..''..''\
@@ -41,32 +53,32 @@ func REPL() {
    \..''..''..''/
     '.''..''...'
       '-..::-' Golfcart v%v`+"\n", VERSION)
-	context := Context{}
-	context.Init()
-	InjectRuntime(&context)
-	for {
-		endlessREPL(&context)
+	for !endlessREPL(context) {
 	}
 }
 
-func endlessREPL(context *Context) {
+func endlessREPL(context *Context) (done bool) {
 	defer func() {
 		recover()
 	}()
 	for {
-		fmt.Print("λ ")
-		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-		ast, err := GenerateAST(scanner.Text())
+		fmt.Fprint(context.stdout, "λ ")
+		line, err := context.stdin.readLine()
+		if err != nil {
+			fmt.Fprintln(context.stdout)
+			return true
+		}
+		ast, err := GenerateAST(line)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(context.stderr, err)
+			continue
 		}
 
 		result, err := ast.Eval(context)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(context.stderr, err)
 			continue
 		}
-		fmt.Println(result)
+		fmt.Fprintln(context.stdout, result)
 	}
 }
@@ -1,37 +1,158 @@
 package golfcart
 
 import (
-	"bufio"
+	gocontext "context"
 	"fmt"
-	"os"
+	"io"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
 func setNativeFunc(key string, nativeFunc Value, frame *StackFrame) {
-	frame.entries[key] = nativeFunc
+	frame.entries[key] = bindNative(nativeFunc, frame.context, lexer.Position{})
 }
 
+// Capability groups of runtime natives, for use with InjectRuntimeWith.
+const (
+	CapabilityCore   = "core"
+	CapabilityIO     = "io"
+	CapabilityTime   = "time"
+	CapabilityImport = "import"
+)
+
+var runtimeCapabilities = map[string][]string{
+	CapabilityCore:   {"assert", "same", "throw", "type", "str", "num", "int", "bigint", "div", "is_int", "len", "bytes", "runes", "keys", "values"},
+	CapabilityIO:     {"in", "log"},
+	CapabilityTime:   {"time"},
+	CapabilityImport: {"import"},
+}
+
+func runtimeNatives() map[string]func(*Execution, []Value) (Value, error) {
+	return map[string]func(*Execution, []Value) (Value, error){
+		"assert": golfcartAssert,
+		"same":   golfcartSame,
+		"throw":  golfcartThrow,
+		"in":     golfcartIn,
+		"log":    golfcartLog,
+		"type":   golfcartType,
+		"str":    golfcartStr,
+		"num":    golfcartNum,
+		"int":    golfcartInt,
+		"bigint": golfcartBigint,
+		"div":    golfcartDiv,
+		"is_int": golfcartIsInt,
+		"len":    golfcartLen,
+		"bytes":  golfcartBytes,
+		"runes":  golfcartRunes,
+		"keys":   golfcartKeys,
+		"values": golfcartValues,
+		"time":   golfcartTime,
+		"import": golfcartImport,
+	}
+}
+
+// InjectRuntime installs the core, io and time natives. import() reads
+// files, so it has to be granted with InjectRuntimeWith.
 func InjectRuntime(context *Context) {
-	setNativeFunc("assert", NativeFunctionValue{name: "assert", Exec: golfcartAssert}, &context.stackFrame)
-	setNativeFunc("in", NativeFunctionValue{name: "in", Exec: golfcartIn}, &context.stackFrame)
-	setNativeFunc("log", NativeFunctionValue{name: "log", Exec: golfcartLog}, &context.stackFrame)
-	setNativeFunc("type", NativeFunctionValue{name: "type", Exec: golfcartType}, &context.stackFrame)
-	setNativeFunc("str", NativeFunctionValue{name: "str", Exec: golfcartStr}, &context.stackFrame)
-	setNativeFunc("num", NativeFunctionValue{name: "num", Exec: golfcartNum}, &context.stackFrame)
-	setNativeFunc("len", NativeFunctionValue{name: "len", Exec: golfcartLen}, &context.stackFrame)
-	setNativeFunc("keys", NativeFunctionValue{name: "keys", Exec: golfcartKeys}, &context.stackFrame)
-	setNativeFunc("values", NativeFunctionValue{name: "values", Exec: golfcartValues}, &context.stackFrame)
-	setNativeFunc("time", NativeFunctionValue{name: "time", Exec: golfcartTime}, &context.stackFrame)
+	InjectRuntimeWith(context, CapabilityCore, CapabilityIO, CapabilityTime)
+}
+
+// InjectRuntimeWith installs only the allowed runtime natives. Each entry
+// is either a capability group (core, io, time, import) or the name of a
+// native. Modules the context imports are given the same natives.
+func InjectRuntimeWith(context *Context, allowed ...string) error {
+	natives := runtimeNatives()
+	granted := make([]string, 0)
+	for _, name := range allowed {
+		if group, ok := runtimeCapabilities[name]; ok {
+			granted = append(granted, group...)
+		} else if _, ok := natives[name]; ok {
+			granted = append(granted, name)
+		} else {
+			return fmt.Errorf("unknown runtime capability or native: '%v'", name)
+		}
+	}
+	for _, name := range granted {
+		setNativeFunc(name, NativeFunctionValue{name: name, Exec: natives[name]}, &context.stackFrame)
+	}
+	context.runtime = append(context.runtime, granted...)
+	return nil
 }
 
 type NativeFunctionValue struct {
-	Pos  lexer.Position
-	name string
-	Exec func([]Value) (Value, error)
+	Pos     lexer.Position
+	name    string
+	Exec    func(*Execution, []Value) (Value, error)
+	context *Context
+}
+
+// bindNative records the context a native was installed or evaluated in,
+// so calls from outside an evaluation (e.g. through FromValue) still run
+// against its streams, limits and cancellation. Values that aren't unbound
+// natives are returned as they are.
+func bindNative(value Value, context *Context, pos lexer.Position) Value {
+	native, okNative := value.(NativeFunctionValue)
+	if !okNative || context == nil {
+		return value
+	}
+	if native.context == nil {
+		native.context = context.rootContext()
+	}
+	if native.Pos == (lexer.Position{}) {
+		native.Pos = pos
+	}
+	return native
+}
+
+// NewNativeFunction wraps a Go function so it can be called from Golfcart.
+func NewNativeFunction(name string, exec func(*Execution, []Value) (Value, error)) NativeFunctionValue {
+	return NativeFunctionValue{name: name, Exec: exec}
+}
+
+// Execution is the handle a native function receives for each call. It
+// gives access to the calling frame and position, the context's streams,
+// and a way to call back into Golfcart functions.
+type Execution struct {
+	Pos     lexer.Position
+	Frame   *StackFrame
+	context *Context
+}
+
+// Context returns the Go context that evaluation is checking for cancellation.
+func (execution *Execution) Context() gocontext.Context {
+	return execution.context.ctx
+}
+
+// ReadLine reads the next line from the context's stdin.
+func (execution *Execution) ReadLine() (string, error) {
+	return execution.context.stdin.readLine()
+}
+
+// Stdout returns the context's stdout.
+func (execution *Execution) Stdout() io.Writer {
+	return execution.context.stdout
+}
+
+// Stderr returns the context's stderr.
+func (execution *Execution) Stderr() io.Writer {
+	return execution.context.stderr
+}
+
+// Call invokes a Golfcart function or native from inside a native,
+// subject to the same limits and cancellation as a call expression.
+func (execution *Execution) Call(fn Value, args ...Value) (Value, error) {
+	return execution.Frame.callFunction(execution.Pos, unref(fn), args)
+}
+
+// Name returns the name the native function was registered with.
+func (nativeFunctionValue NativeFunctionValue) Name() string {
+	return nativeFunctionValue.name
 }
 
 func (nativeFunctionValue NativeFunctionValue) String() string {
@@ -45,7 +166,7 @@ func (nativeFunctionValue NativeFunctionValue) Equals(other Value) (bool, error)
 	return false, nil
 }
 
-func golfcartAssert(args []Value) (Value, error) {
+func golfcartAssert(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("assert() expects 2 arguments")
 	}
@@ -54,35 +175,62 @@ func golfcartAssert(args []Value) (Value, error) {
 		return nil, err
 	}
 	if !equal {
-		return nil, fmt.Errorf("assert failed: %v == %v", args[0], args[1])
+		return nil, ErrorValue{
+			message: fmt.Sprintf("assert failed: %v == %v", args[0], args[1]),
+			kind:    "assert",
+			pos:     execution.Pos,
+			value:   NilValue{},
+		}
 	}
 	return NilValue{}, nil
 }
 
-func golfcartIn(args []Value) (Value, error) {
+// golfcartSame checks identity, for when two lists or dicts being equal
+// isn't enough.
+func golfcartSame(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("same() expects 2 arguments")
+	}
+	same, err := sameValue(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return BoolValue{val: same}, nil
+}
+
+func golfcartThrow(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("throw() expects 1 argument")
+	}
+	if errorValue, okErr := args[0].(ErrorValue); okErr {
+		return nil, errorValue
+	}
+	return nil, ErrorValue{message: args[0].String(), kind: "thrown", pos: execution.Pos, value: args[0]}
+}
+
+func golfcartIn(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("in() expects 1 string argument")
 	}
 	value := args[0]
 	if strValue, okStr := value.(StringValue); okStr {
-		golfcartLog([]Value{strValue})
-		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-		return StringValue{val: []byte(scanner.Text())}, nil
+		golfcartLog(execution, []Value{strValue})
+		line, _ := execution.ReadLine()
+		return StringValue{val: []byte(line)}, nil
 	}
 	return nil, fmt.Errorf("in() expects 1 string argument")
 }
 
-func golfcartLog(args []Value) (Value, error) {
+func golfcartLog(execution *Execution, args []Value) (Value, error) {
 	s := make([]string, len(args))
 	for i := range args {
 		s[i] = args[i].String()
 	}
-	fmt.Println(strings.Join(s, ", "))
+	fmt.Fprintln(execution.Stdout(), strings.Join(s, ", "))
 	return NilValue{}, nil
 }
 
-func golfcartStr(args []Value) (Value, error) {
+func golfcartStr(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("str() expects 1 argument of type num or bool")
 	}
@@ -91,7 +239,13 @@ func golfcartStr(args []Value) (Value, error) {
 		return strValue, nil
 	}
 	if numValue, okNum := value.(NumberValue); okNum {
-		return StringValue{val: []byte(nvToS(numValue))}, nil
+		s := nvToS(numValue)
+		if numValue.big != nil {
+			if err := execution.context.alloc(execution.Pos, len(s)); err != nil {
+				return nil, err
+			}
+		}
+		return StringValue{val: []byte(s)}, nil
 	}
 	if boolValue, okBool := value.(BoolValue); okBool {
 		if boolValue.val {
@@ -103,7 +257,7 @@ func golfcartStr(args []Value) (Value, error) {
 	return nil, fmt.Errorf("str() expects 1 argument of type string, number, or bool")
 }
 
-func golfcartNum(args []Value) (Value, error) {
+func golfcartNum(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("num() expects 1 argument")
 	}
@@ -115,6 +269,9 @@ func golfcartNum(args []Value) (Value, error) {
 	if !okStr {
 		return nil, fmt.Errorf("num() expects 1 argument of type str")
 	}
+	if i, ok := parseInt(string(strValue.val)); ok {
+		return i, nil
+	}
 	f, err := strconv.ParseFloat(string(strValue.val), 64)
 	if err != nil {
 		return nil, fmt.Errorf("num() couldn't convert '%v' to num", strValue)
@@ -122,7 +279,72 @@ func golfcartNum(args []Value) (Value, error) {
 	return NumberValue{val: f}, nil
 }
 
-func golfcartType(args []Value) (Value, error) {
+// golfcartInt truncates a number, or parses a string, to an integer.
+func golfcartInt(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("int() expects 1 argument")
+	}
+	value, err := golfcartNum(execution, args)
+	if err != nil {
+		return nil, fmt.Errorf("int() expects 1 argument of type number or string")
+	}
+	numValue := value.(NumberValue)
+	if numValue.big != nil {
+		return nil, fmt.Errorf("int() couldn't fit %v in 64 bits, use bigint()", numValue)
+	}
+	if numValue.isInt {
+		return numValue, nil
+	}
+	if math.IsNaN(numValue.val) || numValue.val >= math.MaxInt64 || numValue.val < math.MinInt64 {
+		return nil, fmt.Errorf("int() couldn't convert %v to an integer", numValue)
+	}
+	return intValue(int64(numValue.val)), nil
+}
+
+// golfcartBigint is like int() but for integers of any size.
+func golfcartBigint(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("bigint() expects 1 argument")
+	}
+	value, err := golfcartNum(execution, args)
+	if err != nil {
+		return nil, fmt.Errorf("bigint() expects 1 argument of type number or string")
+	}
+	numValue := value.(NumberValue)
+	if numValue.isInt {
+		return numValue, nil
+	}
+	if math.IsNaN(numValue.val) || math.IsInf(numValue.val, 0) {
+		return nil, fmt.Errorf("bigint() couldn't convert %v to an integer", numValue)
+	}
+	n, _ := big.NewFloat(numValue.val).Int(nil)
+	return chargeBig(execution.context, execution.Pos, bigValue(n))
+}
+
+// golfcartDiv is floor division, exact for integers.
+func golfcartDiv(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("div() expects 2 arguments of type number")
+	}
+	left, okLeft := args[0].(NumberValue)
+	right, okRight := args[1].(NumberValue)
+	if !okLeft || !okRight {
+		return nil, fmt.Errorf("div() expects 2 arguments of type number")
+	}
+	return numberDiv(execution.context, execution.Pos, left, right)
+}
+
+// golfcartIsInt reports whether a value is an integer, as opposed to a
+// float or something that isn't a number.
+func golfcartIsInt(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("is_int() expects 1 argument")
+	}
+	numValue, okNum := args[0].(NumberValue)
+	return BoolValue{val: okNum && numValue.isInt}, nil
+}
+
+func golfcartType(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("type() expects 1 argument")
 	}
@@ -144,28 +366,96 @@ func golfcartType(args []Value) (Value, error) {
 		return StringValue{val: []byte("dict")}, nil
 	case NilValue:
 		return StringValue{val: []byte("nil")}, nil
+	case ErrorValue:
+		return StringValue{val: []byte("error")}, nil
 	}
 	return nil, fmt.Errorf("unknown type")
 }
 
-func golfcartLen(args []Value) (Value, error) {
+func golfcartLen(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("len() expects 1 argument of type string, list, or dict")
 	}
 	value := args[0]
 	if stringVal, okStr := value.(StringValue); okStr {
-		return NumberValue{val: float64(len(stringVal.val))}, nil
+		return intValue(int64(utf8.RuneCount(stringVal.val))), nil
 	}
 	if listVal, okList := value.(ListValue); okList {
-		return NumberValue{val: float64(len(listVal.val))}, nil
+		return intValue(int64(len(listVal.val))), nil
 	}
 	if dictVal, okDict := value.(DictValue); okDict {
-		return NumberValue{val: float64(len(dictVal.val))}, nil
+		return intValue(int64(len(dictVal.val))), nil
 	}
 	return nil, fmt.Errorf("len() expects 1 argument of type string, list, or dict")
 }
 
-func golfcartKeys(args []Value) (Value, error) {
+// golfcartBytes converts a string to a list of its UTF-8 bytes, or a list
+// of bytes back to a string.
+func golfcartBytes(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("bytes() expects 1 argument of type string or list")
+	}
+	if strValue, okStr := args[0].(StringValue); okStr {
+		if err := execution.context.alloc(execution.Pos, len(strValue.val)*approxValueBytes); err != nil {
+			return nil, err
+		}
+		items := make([]Value, len(strValue.val))
+		for i, b := range strValue.val {
+			items[i] = intValue(int64(b))
+		}
+		return NewList(items), nil
+	}
+	if listValue, okList := args[0].(ListValue); okList {
+		if err := execution.context.alloc(execution.Pos, len(listValue.val)); err != nil {
+			return nil, err
+		}
+		b := make([]byte, len(listValue.val))
+		for i := range b {
+			numValue, okNum := unref(*listValue.val[i]).(NumberValue)
+			if !okNum || !numValue.isInt || numValue.big != nil || numValue.ival < 0 || numValue.ival > 255 {
+				return nil, fmt.Errorf("bytes() expects a list of numbers from 0 to 255, not: %v", *listValue.val[i])
+			}
+			b[i] = byte(numValue.ival)
+		}
+		return StringValue{val: b}, nil
+	}
+	return nil, fmt.Errorf("bytes() expects 1 argument of type string or list")
+}
+
+// golfcartRunes converts a string to a list of its code points, or a list
+// of code points back to a string.
+func golfcartRunes(execution *Execution, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("runes() expects 1 argument of type string or list")
+	}
+	if strValue, okStr := args[0].(StringValue); okStr {
+		if err := execution.context.alloc(execution.Pos, utf8.RuneCount(strValue.val)*approxValueBytes); err != nil {
+			return nil, err
+		}
+		items := make([]Value, 0, len(strValue.val))
+		for _, r := range string(strValue.val) {
+			items = append(items, intValue(int64(r)))
+		}
+		return NewList(items), nil
+	}
+	if listValue, okList := args[0].(ListValue); okList {
+		var b strings.Builder
+		for i := 0; i < len(listValue.val); i++ {
+			numValue, okNum := unref(*listValue.val[i]).(NumberValue)
+			if !okNum || !numValue.isInt || numValue.big != nil || numValue.ival > utf8.MaxRune || !utf8.ValidRune(rune(numValue.ival)) {
+				return nil, fmt.Errorf("runes() expects a list of valid code points, not: %v", *listValue.val[i])
+			}
+			b.WriteRune(rune(numValue.ival))
+		}
+		if err := execution.context.alloc(execution.Pos, b.Len()); err != nil {
+			return nil, err
+		}
+		return StringValue{val: []byte(b.String())}, nil
+	}
+	return nil, fmt.Errorf("runes() expects 1 argument of type string or list")
+}
+
+func golfcartKeys(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("keys() expects 1 argument of type dict")
 	}
@@ -174,6 +464,9 @@ func golfcartKeys(args []Value) (Value, error) {
 		keys := make(map[int]*Value, len(dictVal.val))
 		i := 0
 		for k := range dictVal.val {
+			if err := execution.context.alloc(execution.Pos, len(k)+approxValueBytes); err != nil {
+				return nil, err
+			}
 			var value Value
 			value = StringValue{val: []byte(k)}
 			keys[i] = &value
@@ -184,12 +477,15 @@ func golfcartKeys(args []Value) (Value, error) {
 	return nil, fmt.Errorf("keys() expects 1 argument of type dict")
 }
 
-func golfcartValues(args []Value) (Value, error) {
+func golfcartValues(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("values() expects 1 argument of type dict")
 	}
 	value := args[0]
 	if dictVal, okDict := value.(DictValue); okDict {
+		if err := execution.context.alloc(execution.Pos, len(dictVal.val)*approxValueBytes); err != nil {
+			return nil, err
+		}
 		values := make(map[int]*Value, len(dictVal.val))
 		i := 0
 		for _, v := range dictVal.val {
@@ -202,9 +498,9 @@ func golfcartValues(args []Value) (Value, error) {
 	return nil, fmt.Errorf("values() expects 1 argument of type dict")
 }
 
-func golfcartTime(args []Value) (Value, error) {
+func golfcartTime(execution *Execution, args []Value) (Value, error) {
 	if len(args) != 0 {
 		return nil, fmt.Errorf("time() expects 0 arguments")
 	}
-	return NumberValue{val: float64(time.Now().UnixNano() / int64(time.Millisecond))}, nil
+	return intValue(time.Now().UnixNano() / int64(time.Millisecond)), nil
 }
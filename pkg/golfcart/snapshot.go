@@ -0,0 +1,259 @@
+package golfcart
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SnapshotVersion is the version of the file format written by Snapshot.
+const SnapshotVersion = 1
+
+// A snapshot file is JSON. Lists and dicts are stored once in objects and
+// referred to by index, which preserves shared references and cycles.
+// Strings that aren't valid UTF-8 would be mangled as JSON strings, so they
+// are stored as base64 bytes instead.
+type snapshotFile struct {
+	Version int                      `json:"version"`
+	Globals map[string]snapshotValue `json:"globals"`
+	Objects []snapshotObject         `json:"objects"`
+}
+
+type snapshotValue struct {
+	Kind   string `json:"kind"`
+	Number string `json:"number,omitempty"`
+	String string `json:"string,omitempty"`
+	Bytes  []byte `json:"bytes,omitempty"`
+	Bool   bool   `json:"bool,omitempty"`
+	Ref    int    `json:"ref,omitempty"`
+}
+
+type snapshotObject struct {
+	Kind       string                   `json:"kind"`
+	Items      []snapshotValue          `json:"items,omitempty"`
+	Entries    map[string]snapshotValue `json:"entries,omitempty"`
+	RawEntries []snapshotEntry          `json:"raw_entries,omitempty"`
+}
+
+// snapshotEntry is a dict entry whose key isn't valid UTF-8.
+type snapshotEntry struct {
+	Key   []byte        `json:"key"`
+	Value snapshotValue `json:"value"`
+}
+
+type snapshotEncoder struct {
+	objects []snapshotObject
+	ids     map[uintptr]int
+}
+
+// Snapshot writes the context's global frame to w. Numbers, strings, bools,
+// nil, lists and dicts are saved. Natives bound directly to a global are
+// skipped, since the host installs those, but functions and natives
+// anywhere else are an error.
+func (context *Context) Snapshot(w io.Writer) error {
+	encoder := snapshotEncoder{objects: make([]snapshotObject, 0), ids: make(map[uintptr]int)}
+	file := snapshotFile{Version: SnapshotVersion, Globals: make(map[string]snapshotValue)}
+
+	names := make([]string, 0, len(context.stackFrame.entries))
+	for name := range context.stackFrame.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := unref(context.stackFrame.entries[name])
+		if _, okNatFunc := value.(NativeFunctionValue); okNatFunc {
+			continue
+		}
+		encoded, err := encoder.encode(value, name)
+		if err != nil {
+			return err
+		}
+		file.Globals[name] = encoded
+	}
+	file.Objects = encoder.objects
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(file)
+}
+
+func (encoder *snapshotEncoder) encode(value Value, path string) (snapshotValue, error) {
+	switch value := unref(value).(type) {
+	case NilValue:
+		return snapshotValue{Kind: "nil"}, nil
+	case BoolValue:
+		return snapshotValue{Kind: "bool", Bool: value.val}, nil
+	case NumberValue:
+		if value.big != nil {
+			return snapshotValue{Kind: "big", Number: value.big.String()}, nil
+		}
+		if value.isInt {
+			return snapshotValue{Kind: "int", Number: strconv.FormatInt(value.ival, 10)}, nil
+		}
+		return snapshotValue{Kind: "number", Number: strconv.FormatFloat(value.val, 'g', -1, 64)}, nil
+	case StringValue:
+		if !utf8.Valid(value.val) {
+			return snapshotValue{Kind: "bytes", Bytes: value.val}, nil
+		}
+		return snapshotValue{Kind: "string", String: string(value.val)}, nil
+	case ListValue:
+		id, seen := encoder.object(reflect.ValueOf(value.val).Pointer(), "list")
+		if !seen {
+			items := make([]snapshotValue, len(value.val))
+			for i := range items {
+				item, err := encoder.encode(*value.val[i], fmt.Sprintf("%v[%v]", path, i))
+				if err != nil {
+					return snapshotValue{}, err
+				}
+				items[i] = item
+			}
+			encoder.objects[id].Items = items
+		}
+		return snapshotValue{Kind: "ref", Ref: id}, nil
+	case DictValue:
+		id, seen := encoder.object(reflect.ValueOf(value.val).Pointer(), "dict")
+		if !seen {
+			entries := make(map[string]snapshotValue, len(value.val))
+			for key, entry := range value.val {
+				encoded, err := encoder.encode(*entry, fmt.Sprintf("%v.%v", path, key))
+				if err != nil {
+					return snapshotValue{}, err
+				}
+				if !utf8.ValidString(key) {
+					encoder.objects[id].RawEntries = append(encoder.objects[id].RawEntries, snapshotEntry{Key: []byte(key), Value: encoded})
+					continue
+				}
+				entries[key] = encoded
+			}
+			sort.Slice(encoder.objects[id].RawEntries, func(i, j int) bool {
+				return string(encoder.objects[id].RawEntries[i].Key) < string(encoder.objects[id].RawEntries[j].Key)
+			})
+			encoder.objects[id].Entries = entries
+		}
+		return snapshotValue{Kind: "ref", Ref: id}, nil
+	}
+	golfType, err := golfcartType(nil, []Value{value})
+	if err != nil {
+		return snapshotValue{}, err
+	}
+	return snapshotValue{}, fmt.Errorf("cannot snapshot %v: values of type %v can't be saved", path, golfType)
+}
+
+// object returns the id of the list or dict at ptr, registering it first if
+// it hasn't been seen. Registering before encoding the contents is what
+// lets cycles refer back to it.
+func (encoder *snapshotEncoder) object(ptr uintptr, kind string) (int, bool) {
+	if id, ok := encoder.ids[ptr]; ok {
+		return id, true
+	}
+	id := len(encoder.objects)
+	encoder.ids[ptr] = id
+	encoder.objects = append(encoder.objects, snapshotObject{Kind: kind})
+	return id, false
+}
+
+// Restore reads a snapshot written by Snapshot and binds its globals in the
+// context's global frame, replacing any existing bindings of the same name.
+func (context *Context) Restore(r io.Reader) error {
+	var file snapshotFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return fmt.Errorf("cannot read snapshot: %v", err)
+	}
+	if file.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %v, expected: %v", file.Version, SnapshotVersion)
+	}
+
+	// Create every list and dict before filling them so refs can point anywhere
+	objects := make([]Value, len(file.Objects))
+	for i, object := range file.Objects {
+		switch object.Kind {
+		case "list":
+			objects[i] = ListValue{val: make(map[int]*Value, len(object.Items))}
+		case "dict":
+			objects[i] = DictValue{val: make(map[string]*Value, len(object.Entries))}
+		default:
+			return fmt.Errorf("invalid snapshot: unknown object kind '%v'", object.Kind)
+		}
+	}
+	for i, object := range file.Objects {
+		if listValue, okList := objects[i].(ListValue); okList {
+			for _, item := range object.Items {
+				value, err := decodeSnapshotValue(item, objects)
+				if err != nil {
+					return err
+				}
+				listValue.Append(value)
+			}
+		}
+		if dictValue, okDict := objects[i].(DictValue); okDict {
+			for key, entry := range object.Entries {
+				value, err := decodeSnapshotValue(entry, objects)
+				if err != nil {
+					return err
+				}
+				dictValue.Set(key, value)
+			}
+			for _, entry := range object.RawEntries {
+				value, err := decodeSnapshotValue(entry.Value, objects)
+				if err != nil {
+					return err
+				}
+				dictValue.Set(string(entry.Key), value)
+			}
+		}
+	}
+
+	globals := make(map[string]Value, len(file.Globals))
+	for name, encoded := range file.Globals {
+		value, err := decodeSnapshotValue(encoded, objects)
+		if err != nil {
+			return err
+		}
+		globals[name] = value
+	}
+	for name, value := range globals {
+		context.stackFrame.entries[name] = value
+	}
+	return nil
+}
+
+func decodeSnapshotValue(encoded snapshotValue, objects []Value) (Value, error) {
+	switch encoded.Kind {
+	case "nil":
+		return NilValue{}, nil
+	case "bool":
+		return BoolValue{val: encoded.Bool}, nil
+	case "number":
+		n, err := strconv.ParseFloat(encoded.Number, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: bad number '%v'", encoded.Number)
+		}
+		return NumberValue{val: n}, nil
+	case "int":
+		n, err := strconv.ParseInt(encoded.Number, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: bad int '%v'", encoded.Number)
+		}
+		return intValue(n), nil
+	case "big":
+		n, ok := parseInt(encoded.Number)
+		if !ok {
+			return nil, fmt.Errorf("invalid snapshot: bad big integer '%v'", encoded.Number)
+		}
+		return n, nil
+	case "string":
+		return StringValue{val: []byte(encoded.String)}, nil
+	case "bytes":
+		return StringValue{val: encoded.Bytes}, nil
+	case "ref":
+		if encoded.Ref < 0 || encoded.Ref >= len(objects) {
+			return nil, fmt.Errorf("invalid snapshot: ref %v out of range", encoded.Ref)
+		}
+		return objects[encoded.Ref], nil
+	}
+	return nil, fmt.Errorf("invalid snapshot: unknown value kind '%v'", encoded.Kind)
+}
@@ -24,7 +24,7 @@ func runAllProgramsInDir(t *testing.T, path string) {
 			log.Fatal(err)
 		}
 		source := string(b)
-		_, err = golfcart.RunProgram(source, false)
+		_, err = golfcart.RunProgramFile(source, cur, false)
 		if err != nil {
 			t.Errorf("RunProgram(%s): %v", cur, err)
 		}
@@ -56,7 +56,7 @@ func TestBadPrograms(t *testing.T) {
 			log.Fatal(err)
 		}
 		source := string(b)
-		_, err = golfcart.RunProgram(source, false)
+		_, err = golfcart.RunProgramFile(source, cur, false)
 		if err == nil {
 			t.Errorf("RunProgram(%s): didn't throw an error", cur)
 		}
@@ -0,0 +1,910 @@
+package golfcart
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/healeycodes/golfcart/pkg/golfcart"
+)
+
+func TestInterpreterGlobals(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.SetGlobal("limit", golfcart.NewNumber(3))
+	interpreter.SetGlobal("names", golfcart.NewList([]golfcart.Value{golfcart.NewString("a"), golfcart.NewString("b")}))
+
+	result, err := interpreter.Eval("total = limit * 2 names.append(\"c\") len(names)")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if num, ok := result.(golfcart.NumberValue); !ok || num.Val() != 3 {
+		t.Errorf("Eval: expected 3, got %v", result)
+	}
+
+	total, err := interpreter.GetGlobal("total")
+	if err != nil {
+		t.Fatalf("GetGlobal: %v", err)
+	}
+	if num, ok := total.(golfcart.NumberValue); !ok || num.Val() != 6 {
+		t.Errorf("GetGlobal: expected 6, got %v", total)
+	}
+
+	names, _ := interpreter.GetGlobal("names")
+	if list, ok := names.(golfcart.ListValue); !ok || list.Val()[2].String() != "c" {
+		t.Errorf("GetGlobal: expected list ending in c, got %v", names)
+	}
+
+	if _, err := interpreter.GetGlobal("missing"); err == nil {
+		t.Errorf("GetGlobal: expected an error for a missing global")
+	}
+}
+
+func TestInterpreterRegisterNative(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.RegisterNative("greet", func(execution *golfcart.Execution, args []golfcart.Value) (golfcart.Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("greet() expects 1 argument")
+		}
+		return golfcart.NewString("hello " + args[0].String()), nil
+	})
+
+	result, err := interpreter.Eval("greet(\"golfcart\")")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if str, ok := result.(golfcart.StringValue); !ok || str.Val() != "hello golfcart" {
+		t.Errorf("Eval: expected 'hello golfcart', got %v", result)
+	}
+
+	if _, err := interpreter.Eval("greet()"); err == nil {
+		t.Errorf("Eval: expected native error to propagate")
+	}
+}
+
+type point struct {
+	X      float64 `golfcart:"x"`
+	Y      float64 `golfcart:"y"`
+	Label  string
+	hidden bool
+	Skip   int `golfcart:"-"`
+}
+
+func TestToValue(t *testing.T) {
+	value, err := golfcart.ToValue(map[string]interface{}{
+		"points": []point{{X: 1, Y: 2, Label: "a"}},
+		"count":  uint8(1),
+		"none":   (*point)(nil),
+	})
+	if err != nil {
+		t.Fatalf("ToValue: %v", err)
+	}
+
+	interpreter := golfcart.NewInterpreter()
+	interpreter.SetGlobal("data", value)
+	_, err = interpreter.Eval(`
+assert(data.points[0].x + data.points[0].y, 3)
+assert(data.points[0].Label, "a")
+assert(data.points[0].Skip, nil)
+assert(data.count, 1)
+assert(data.none, nil)`)
+	if err != nil {
+		t.Errorf("Eval: %v", err)
+	}
+
+	if _, err := golfcart.ToValue(make(chan int)); err == nil {
+		t.Errorf("ToValue: expected an error for a channel")
+	}
+	if _, err := golfcart.ToValue(map[int]string{}); err == nil {
+		t.Errorf("ToValue: expected an error for non-string map keys")
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	result, err := interpreter.Eval(`[{x: 1, y: 2, Label: "a"}, {x: 3, y: 4, Label: "b"}]`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+
+	var points []point
+	if err := golfcart.FromValue(result, &points); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	if len(points) != 2 || points[1].X != 3 || points[1].Label != "b" {
+		t.Errorf("FromValue: unexpected result %+v", points)
+	}
+
+	var generic interface{}
+	if err := golfcart.FromValue(result, &generic); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	if items, ok := generic.([]interface{}); !ok || items[0].(map[string]interface{})["y"] != int64(2) {
+		t.Errorf("FromValue: unexpected result %#v", generic)
+	}
+
+	var n int
+	if err := golfcart.FromValue(golfcart.NewNumber(1.5), &n); err == nil {
+		t.Errorf("FromValue: expected an error storing 1.5 in an int")
+	}
+	var s string
+	if err := golfcart.FromValue(golfcart.NewNumber(1), &s); err == nil {
+		t.Errorf("FromValue: expected an error storing a number in a string")
+	}
+	if err := golfcart.FromValue(golfcart.NewNumber(1), n); err == nil {
+		t.Errorf("FromValue: expected an error for a non-pointer target")
+	}
+	if err := golfcart.FromValue(nil, &generic); err == nil {
+		t.Errorf("FromValue: expected an error for a nil Value")
+	}
+}
+
+func TestToValueFunc(t *testing.T) {
+	value, err := golfcart.ToValue(func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	})
+	if err != nil {
+		t.Fatalf("ToValue: %v", err)
+	}
+
+	interpreter := golfcart.NewInterpreter()
+	interpreter.SetGlobal("div", value)
+	if _, err := interpreter.Eval(`assert(div(7, 2), 3)`); err != nil {
+		t.Errorf("Eval: %v", err)
+	}
+	if _, err := interpreter.Eval(`div(1, 0)`); err == nil {
+		t.Errorf("Eval: expected the Go error to propagate")
+	}
+	if _, err := interpreter.Eval(`div(1.5, 1)`); err == nil {
+		t.Errorf("Eval: expected an error converting 1.5 to int")
+	}
+	if _, err := interpreter.Eval(`div(1)`); err == nil {
+		t.Errorf("Eval: expected an error for a missing argument")
+	}
+
+	sum, err := golfcart.ToValue(func(first int, rest ...int) (int, error) {
+		for _, n := range rest {
+			first += n
+		}
+		return first, nil
+	})
+	if err != nil {
+		t.Fatalf("ToValue: %v", err)
+	}
+	interpreter.SetGlobal("sum", sum)
+	if _, err := interpreter.Eval(`assert(sum(1, 2, 3), 6)`); err != nil {
+		t.Errorf("Eval: %v", err)
+	}
+	if _, err := interpreter.Eval(`sum()`); err == nil || !strings.Contains(err.Error(), "wanted at least: 1") {
+		t.Errorf("Eval: expected an error wanting at least 1 argument, got %v", err)
+	}
+
+	// A list of results is charged like any other list
+	pair, err := golfcart.ToValue(func() (int, int) { return 1, 2 })
+	if err != nil {
+		t.Fatalf("ToValue: %v", err)
+	}
+	interpreter.SetGlobal("pair", pair)
+	interpreter.Context().SetLimits(golfcart.Limits{MaxAllocBytes: 1000})
+	_, err = interpreter.Eval(`for i = 0; i < 1000; i += 1 { pair() }`)
+	var limitErr golfcart.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Eval: expected LimitError for a list of results, got %v", err)
+	}
+}
+
+func TestContextStreams(t *testing.T) {
+	var first, second bytes.Buffer
+	a := golfcart.NewInterpreter()
+	a.Context().SetStdout(&first)
+	a.Context().SetStdin(strings.NewReader("alice\nbob\n"))
+	b := golfcart.NewInterpreter()
+	b.Context().SetStdout(&second)
+
+	if _, err := a.Eval(`log(in("name?") + in("again?"))`); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if _, err := b.Eval(`log(1, "two")`); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+
+	if got := first.String(); got != "name?\nagain?\nalicebob\n" {
+		t.Errorf("stdout: got %q", got)
+	}
+	if got := second.String(); got != "1, two\n" {
+		t.Errorf("stdout: got %q", got)
+	}
+}
+
+func TestSharedStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+	w.WriteString("alice\nbob\n")
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	a := golfcart.NewInterpreter()
+	a.Context().SetStdout(&bytes.Buffer{})
+	b := golfcart.NewInterpreter()
+	b.Context().SetStdout(&bytes.Buffer{})
+	b.Context().SetStdin(os.Stdin)
+
+	first, err := a.Eval(`in("")`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	second, err := b.Eval(`in("")`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if first.String() != "alice" || second.String() != "bob" {
+		t.Errorf("in(): expected alice then bob, got %v then %v", first, second)
+	}
+}
+
+func TestRunREPL(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	context := golfcart.Context{}
+	context.Init()
+	golfcart.InjectRuntime(&context)
+	context.SetStdin(strings.NewReader("a = 2\na * 3\nb\n"))
+	context.SetStdout(&stdout)
+	context.SetStderr(&stderr)
+
+	golfcart.RunREPL(&context)
+
+	if !strings.Contains(stdout.String(), "λ 2\nλ 6\n") {
+		t.Errorf("stdout: got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "cannot find value for key 'b'") {
+		t.Errorf("stderr: got %q", stderr.String())
+	}
+}
+
+func TestEvalContextTimeout(t *testing.T) {
+	programs := []string{
+		"for true {}",
+		"spin = () => { for i = 0; true; i = i + 1 {} } spin()",
+		"for x in [1, 2, 3] { for true {} }",
+	}
+	for _, program := range programs {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		interpreter := golfcart.NewInterpreter()
+		_, err := interpreter.EvalContext(ctx, program)
+		cancel()
+
+		var cancelled golfcart.CancelledError
+		if !errors.As(err, &cancelled) {
+			t.Errorf("EvalContext(%q): expected CancelledError, got %v", program, err)
+			continue
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("EvalContext(%q): expected DeadlineExceeded, got %v", program, err)
+		}
+		if cancelled.Pos.Line != 1 {
+			t.Errorf("EvalContext(%q): expected a position, got %v", program, cancelled.Pos)
+		}
+	}
+}
+
+func TestEvalContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	interpreter := golfcart.NewInterpreter()
+	if _, err := interpreter.EvalContext(ctx, "log(1)"); !errors.Is(err, context.Canceled) {
+		t.Errorf("EvalContext: expected context.Canceled, got %v", err)
+	}
+	if _, err := interpreter.Eval("log(1)"); err != nil {
+		t.Errorf("Eval: context should be reset after EvalContext, got %v", err)
+	}
+
+	// A context the host set beforehand is put back, not dropped
+	hostCtx, hostCancel := context.WithCancel(context.Background())
+	interpreter.Context().SetContext(hostCtx)
+	if _, err := interpreter.EvalContext(context.Background(), "log(1)"); err != nil {
+		t.Errorf("EvalContext: %v", err)
+	}
+	hostCancel()
+	if _, err := interpreter.Eval("log(1)"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Eval: expected the host's context to be restored, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits  golfcart.Limits
+		program string
+		limit   string
+	}{
+		{golfcart.Limits{MaxSteps: 100}, "for true {}", "steps"},
+		{golfcart.Limits{MaxCallDepth: 10}, "f = n => f(n + 1) f(0)", "call depth"},
+		{golfcart.Limits{MaxAllocBytes: 1000}, "s = \"ab\" for true { s = s + s }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 1000}, "l = [] for true { l.append(1) }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 1000}, "d = {} for i = 0; true; i = i + 1 { d[str(i)] = i }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 1 << 20}, "n = 3 for true { n *= n }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 150000}, "n = 3 for i = 0; i < 18; i += 1 { n *= n } s = str(n)", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 4000}, "s = \"abcdefghij\" for i = 0; i < 1000; i += 1 { b = bytes(s) }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 4000}, "s = \"abcdéfghij\" for i = 0; i < 1000; i += 1 { r = runes(s) }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 4000}, "d = {a: 1, b: 2} for i = 0; i < 1000; i += 1 { k = keys(d) }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 4000}, "d = {a: 1, b: 2} for i = 0; i < 1000; i += 1 { v = values(d) }", "alloc bytes"},
+		{golfcart.Limits{MaxAllocBytes: 4000}, "f = (...rest) => rest for i = 0; i < 1000; i += 1 { f(1, 2, 3) }", "alloc bytes"},
+	}
+	for _, test := range tests {
+		interpreter := golfcart.NewInterpreter()
+		interpreter.Context().SetLimits(test.limits)
+		_, err := interpreter.Eval(test.program)
+
+		var limitError golfcart.LimitError
+		if !errors.As(err, &limitError) {
+			t.Errorf("Eval(%q): expected LimitError, got %v", test.program, err)
+			continue
+		}
+		if limitError.Limit != test.limit {
+			t.Errorf("Eval(%q): expected %v limit, got %v", test.program, test.limit, limitError.Limit)
+		}
+	}
+}
+
+func TestLimitsAllowSmallPrograms(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.Context().SetLimits(golfcart.Limits{MaxSteps: 10000, MaxCallDepth: 50, MaxAllocBytes: 10000})
+	_, err := interpreter.Eval(`
+fib = n => if n < 2 { n } else { fib(n - 1) + fib(n - 2) }
+assert(fib(10), 55)
+words = ["a", "b"] + ["c"]
+assert(len(words), 3)`)
+	if err != nil {
+		t.Errorf("Eval: %v", err)
+	}
+}
+
+func TestNewInterpreterWith(t *testing.T) {
+	interpreter, err := golfcart.NewInterpreterWith(golfcart.CapabilityCore, "log")
+	if err != nil {
+		t.Fatalf("NewInterpreterWith: %v", err)
+	}
+	if _, err := interpreter.Eval(`assert(len("ab"), 2) log("ok")`); err != nil {
+		t.Errorf("Eval: %v", err)
+	}
+	for _, program := range []string{`in("name?")`, `time()`, `import("lib.golf")`} {
+		if _, err := interpreter.Eval(program); err == nil {
+			t.Errorf("Eval(%q): expected native to be missing", program)
+		}
+	}
+
+	if _, err := golfcart.NewInterpreterWith("network"); err == nil {
+		t.Errorf("NewInterpreterWith: expected an error for an unknown capability")
+	}
+}
+
+func TestErrorValue(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	result, err := interpreter.Eval(`try {
+    throw({code: 7})
+} catch e { e }`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	errorValue, ok := result.(golfcart.ErrorValue)
+	if !ok {
+		t.Fatalf("Eval: expected an error value, got %v", result)
+	}
+	if errorValue.Kind() != "thrown" || errorValue.Pos().Line != 2 || errorValue.Pos().Column != 5 {
+		t.Errorf("ErrorValue: expected a thrown error at 2:5, got %v at %v", errorValue.Kind(), errorValue.Pos())
+	}
+	if dict, ok := errorValue.Value().(golfcart.DictValue); !ok || dict.Val()["code"].String() != "7" {
+		t.Errorf("Value: expected the thrown dict, got %v", errorValue.Value())
+	}
+	if _, ok := golfcart.NewError("runtime", "boom").Value().(golfcart.NilValue); !ok {
+		t.Errorf("Value: expected nil for an error that wasn't thrown")
+	}
+}
+
+func TestInterpreterCall(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	_, err := interpreter.Eval(`
+count = 0
+add = (a, b) => { count = count + 1 return a + b }
+fail = () => assert(1, 2)`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+
+	add, _ := interpreter.GetGlobal("add")
+	result, err := interpreter.Call(add, golfcart.NewNumber(2), golfcart.NewNumber(3))
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if num, ok := result.(golfcart.NumberValue); !ok || num.Val() != 5 {
+		t.Errorf("Call: expected 5, got %v", result)
+	}
+	count, _ := interpreter.GetGlobal("count")
+	if count.String() != "1" {
+		t.Errorf("Call: expected closure to update count, got %v", count)
+	}
+
+	if _, err := interpreter.Call(add, golfcart.NewNumber(2)); err == nil {
+		t.Errorf("Call: expected an error for a missing argument")
+	}
+	fail, _ := interpreter.GetGlobal("fail")
+	if _, err := interpreter.Call(fail); err == nil {
+		t.Errorf("Call: expected the assert to fail")
+	}
+	length, _ := interpreter.GetGlobal("len")
+	if result, err := interpreter.Call(length, golfcart.NewString("abc")); err != nil || result.String() != "3" {
+		t.Errorf("Call: expected native len() to return 3, got %v %v", result, err)
+	}
+	if _, err := interpreter.Call(golfcart.NewNumber(1)); err == nil {
+		t.Errorf("Call: expected an error calling a number")
+	}
+}
+
+func TestFromValueFunc(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	predicate, err := interpreter.Eval(`n => n % 2 == 0`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+
+	var isEven func(int) (bool, error)
+	if err := golfcart.FromValue(predicate, &isEven); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	if even, err := isEven(4); err != nil || !even {
+		t.Errorf("isEven(4): got %v %v", even, err)
+	}
+	if even, err := isEven(3); err != nil || even {
+		t.Errorf("isEven(3): got %v %v", even, err)
+	}
+
+	var broken func(string) (bool, error)
+	golfcart.FromValue(predicate, &broken)
+	if _, err := broken("a"); err == nil {
+		t.Errorf("broken(\"a\"): expected an error")
+	}
+
+	var noError func(int) bool
+	if err := golfcart.FromValue(predicate, &noError); err == nil {
+		t.Errorf("FromValue: expected an error for a func without an error result")
+	}
+
+	// Natives run against the context they came from
+	var stdout bytes.Buffer
+	interpreter.Context().SetStdout(&stdout)
+	interpreter.Context().SetLimits(golfcart.Limits{MaxAllocBytes: 64})
+	log, err := interpreter.Eval(`log`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	var logFunc func(...interface{}) (interface{}, error)
+	if err := golfcart.FromValue(log, &logFunc); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	if _, err := logFunc("hi"); err != nil {
+		t.Fatalf("logFunc: %v", err)
+	}
+	if stdout.String() != "hi\n" {
+		t.Errorf("logFunc: expected output on the interpreter's stdout, got %q", stdout.String())
+	}
+	strFunc, err := interpreter.GetGlobal("str")
+	if err != nil {
+		t.Fatalf("GetGlobal: %v", err)
+	}
+	var toStr func(interface{}) (string, error)
+	if err := golfcart.FromValue(strFunc, &toStr); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	if _, err := toStr(new(big.Int).Lsh(big.NewInt(1), 4096)); err == nil {
+		t.Errorf("toStr: expected the interpreter's limits to apply")
+	}
+
+	unbound := golfcart.NewNativeFunction("unbound", func(*golfcart.Execution, []golfcart.Value) (golfcart.Value, error) {
+		return golfcart.NilValue{}, nil
+	})
+	var unboundFunc func() (interface{}, error)
+	if err := golfcart.FromValue(unbound, &unboundFunc); err == nil {
+		t.Errorf("FromValue: expected an error for an unbound native")
+	}
+}
+
+func TestNativeExecution(t *testing.T) {
+	var stdout bytes.Buffer
+	interpreter := golfcart.NewInterpreter()
+	interpreter.Context().SetStdout(&stdout)
+	interpreter.RegisterNative("map", func(execution *golfcart.Execution, args []golfcart.Value) (golfcart.Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("map() expects 2 arguments")
+		}
+		list, okList := args[0].(golfcart.ListValue)
+		if !okList {
+			return nil, fmt.Errorf("%v map() expects a list", execution.Pos)
+		}
+		mapped := make([]golfcart.Value, 0)
+		for _, item := range list.Val() {
+			result, err := execution.Call(args[1], item)
+			if err != nil {
+				return nil, err
+			}
+			mapped = append(mapped, result)
+		}
+		fmt.Fprintf(execution.Stdout(), "mapped %v items\n", len(mapped))
+		return golfcart.NewList(mapped), nil
+	})
+	interpreter.RegisterNative("lookup", func(execution *golfcart.Execution, args []golfcart.Value) (golfcart.Value, error) {
+		return execution.Frame.Get(args[0].String())
+	})
+
+	result, err := interpreter.Eval(`
+offset = 10
+doubled = map([1, 2, 3], n => n * 2 + offset)
+assert(doubled[2], 16)
+f = () => { secret = 42 lookup("secret") }
+f()`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if result.String() != "42" {
+		t.Errorf("lookup(): expected the caller's frame, got %v", result)
+	}
+	if stdout.String() != "mapped 3 items\n" {
+		t.Errorf("stdout: got %q", stdout.String())
+	}
+
+	_, err = interpreter.Eval(`
+map("nope", n => n)`)
+	if err == nil || !strings.HasPrefix(err.Error(), "2:1") {
+		t.Errorf("map(): expected an error at the call position, got %v", err)
+	}
+
+	interpreter.Context().SetLimits(golfcart.Limits{MaxCallDepth: 5})
+	if _, err := interpreter.Eval(`f = n => map([n], f) f(1)`); err == nil {
+		t.Errorf("map(): expected callbacks to respect the call depth limit")
+	}
+}
+
+func TestCompileRunConcurrently(t *testing.T) {
+	program, err := golfcart.Compile(`
+scores = []
+for i = 0; i < len(words); i = i + 1 {
+	scores.append(len(words[i]) * weight)
+}
+total = 0
+for score in scores { total = total + score }
+check = n => if n > 0 { "positive" } else { "empty" }
+[total, check(total)]`, "rules.golf")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			words := make([]golfcart.Value, i)
+			for j := range words {
+				words[j] = golfcart.NewString("ab")
+			}
+			result, err := program.Run(map[string]golfcart.Value{
+				"words":  golfcart.NewList(words),
+				"weight": golfcart.NewNumber(float64(i)),
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if want := fmt.Sprintf("[%v, ", 2*i*i); !strings.HasPrefix(result.String(), want) {
+				errs <- fmt.Errorf("run %v: expected %v..., got %v", i, want, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCompileFilename(t *testing.T) {
+	if _, err := golfcart.Compile("a = ", "broken.golf"); err == nil || !strings.HasPrefix(err.Error(), "broken.golf:") {
+		t.Errorf("Compile: expected an error mentioning the filename, got %v", err)
+	}
+
+	program, _ := golfcart.Compile("\nmissing", "rules.golf")
+	if _, err := program.Run(nil); err == nil {
+		t.Errorf("Run: expected an error for a missing global")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	_, err := interpreter.Eval(`
+n = 1.5
+s = "hi"
+b = true
+none = nil
+shared = [1, 2]
+d = {a: shared, b: shared, name: "golfcart"}
+cycle = [0]
+cycle.append(cycle)
+raw = bytes([255, 104])
+keyed = {}
+keyed[raw] = raw`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := interpreter.Context().Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	restored := golfcart.NewInterpreter()
+	if err := restored.Context().Restore(&snapshot); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	_, err = restored.Eval(`
+assert(n, 1.5)
+assert(s, "hi")
+assert(b, true)
+assert(none, nil)
+d.a.append(3)
+assert(len(d.b), 3)
+assert(len(shared), 3)
+assert(d.name, "golfcart")
+assert(cycle[1][1][1][0], 0)
+assert(bytes(raw), [255, 104])
+assert(bytes(keyed[bytes([255, 104])]), [255, 104])
+log("still has natives")`)
+	if err != nil {
+		t.Errorf("Eval after Restore: %v", err)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.Eval(`handlers = {start: () => nil}`)
+	err := interpreter.Context().Snapshot(&bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "handlers.start") {
+		t.Errorf("Snapshot: expected an error naming the closure, got %v", err)
+	}
+
+	for _, snapshot := range []string{
+		`{"version": 99, "globals": {}}`,
+		`{"version": 1, "globals": {"a": {"kind": "ref", "ref": 3}}}`,
+		`{"version": 1, "globals": {"a": {"kind": "function"}}}`,
+		`{"version": 1, "globals": {"a": {"kind": "int", "number": "99999999999999999999"}}}`,
+		`{"version": 1, "globals": {"a": {"kind": "big", "number": "1.5"}}}`,
+		`not json`,
+	} {
+		if err := interpreter.Context().Restore(strings.NewReader(snapshot)); err == nil {
+			t.Errorf("Restore(%v): expected an error", snapshot)
+		}
+	}
+}
+
+func TestLimitsCannotBeCaught(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.Context().SetLimits(golfcart.Limits{MaxSteps: 1000})
+	_, err := interpreter.Eval(`try { for true {} } catch e { "caught" }`)
+
+	var limitError golfcart.LimitError
+	if !errors.As(err, &limitError) {
+		t.Errorf("Eval: expected LimitError to escape try, got %v", err)
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	interpreter.SetGlobal("n", golfcart.NewInt(1<<60+1))
+	result, err := interpreter.Eval(`n * 4 + 3`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	var n int64
+	if err := golfcart.FromValue(result, &n); err != nil || n != (1<<60+1)*4+3 {
+		t.Errorf("FromValue: expected an exact int64, got %v (%v)", n, err)
+	}
+	if number := result.(golfcart.NumberValue); !number.IsInt() {
+		t.Errorf("Eval: expected an integer, got %v", result)
+	}
+
+	var buf bytes.Buffer
+	if err := interpreter.Context().Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	restored := golfcart.NewInterpreter()
+	if err := restored.Context().Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := restored.Eval(`assert(str(n), "1152921504606846977")`); err != nil {
+		t.Errorf("Eval after Restore: %v", err)
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	n, _ := new(big.Int).SetString("340282366920938463463374607431768211456", 10)
+	value, err := golfcart.ToValue(n)
+	if err != nil {
+		t.Fatalf("ToValue: %v", err)
+	}
+	interpreter.SetGlobal("n", value)
+	result, err := interpreter.Eval(`n * n + 1`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+
+	var out big.Int
+	if err := golfcart.FromValue(result, &out); err != nil {
+		t.Fatalf("FromValue: %v", err)
+	}
+	want := new(big.Int).Add(new(big.Int).Mul(n, n), big.NewInt(1))
+	if out.Cmp(want) != 0 || result.String() != want.String() {
+		t.Errorf("FromValue: expected %v, got %v", want, &out)
+	}
+	var small int64
+	if err := golfcart.FromValue(result, &small); err == nil {
+		t.Errorf("FromValue: expected an error storing %v in an int64", result)
+	}
+
+	var buf bytes.Buffer
+	interpreter.Eval(`m = 5 + n - n`)
+	if err := interpreter.Context().Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	restored := golfcart.NewInterpreter()
+	if err := restored.Context().Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := restored.Eval(`assert(n, 340282366920938463463374607431768211456) assert(m, 5)`); err != nil {
+		t.Errorf("Eval after Restore: %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golfcart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"lib.golf": `log("loading")
+greeted = try { greet("lib") } catch e { "no greet" }
+timed = try { time() } catch e { "no time" }
+spin = () => for i = 0; true; i += 1 {}`,
+		"bad_exports.golf": `exports = [1]`,
+		"secret.txt":       `vm-hostname-1234`,
+		"not_golf.golf":    `{{ not: "golfcart" }`,
+	}
+	var wide strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&wide, "binding_%v = %v\n", i, i)
+	}
+	files["wide.golf"] = wide.String()
+	for name, source := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compile := func(source string) *golfcart.Program {
+		program, err := golfcart.Compile(source, filepath.Join(dir, "main.golf"))
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		return program
+	}
+
+	if _, err := golfcart.NewInterpreter().Run(compile(`import("lib.golf")`)); err == nil {
+		t.Errorf("Run: expected import() to need the import capability")
+	}
+
+	interpreter, err := golfcart.NewInterpreterWith(golfcart.CapabilityCore, golfcart.CapabilityIO, golfcart.CapabilityImport)
+	if err != nil {
+		t.Fatalf("NewInterpreterWith: %v", err)
+	}
+	var stdout bytes.Buffer
+	interpreter.Context().SetStdout(&stdout)
+	interpreter.RegisterNative("greet", func(execution *golfcart.Execution, args []golfcart.Value) (golfcart.Value, error) {
+		return golfcart.NewString("hello " + args[0].String()), nil
+	})
+	result, err := interpreter.Run(compile(`a = import("lib.golf") b = import("lib.golf") found = [a.greeted, a.timed] found`))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.String() != "[no greet, no time]" {
+		t.Errorf("Run: expected the module to only get granted runtime natives, got %v", result)
+	}
+	if stdout.String() != "loading\n" {
+		t.Errorf("stdout: expected the module to be evaluated once, got %q", stdout.String())
+	}
+
+	_, err = interpreter.Run(compile(`import("bad_exports.golf")`))
+	if err == nil || !strings.Contains(err.Error(), "exports must be a dict") {
+		t.Errorf("Run: expected an error for exports that aren't a dict, got %v", err)
+	}
+	_, err = interpreter.Run(compile(`import("secret.txt")`))
+	if err == nil || strings.Contains(err.Error(), "vm") {
+		t.Errorf("Run: expected an error that doesn't quote the file, got %v", err)
+	}
+	_, err = interpreter.Run(compile(`import("not_golf.golf")`))
+	if err == nil || strings.Contains(err.Error(), "golfcart\"") {
+		t.Errorf("Run: expected a parse error that doesn't quote the file, got %v", err)
+	}
+
+	// The module's functions are still bound by the importer's limits
+	interpreter.Context().SetLimits(golfcart.Limits{MaxSteps: 1000})
+	_, err = interpreter.Eval(`a.spin()`)
+	var limitErr golfcart.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Eval: expected LimitError, got %v", err)
+	}
+
+	// Building the exports dict is charged to the importer
+	limited, err := golfcart.NewInterpreterWith(golfcart.CapabilityCore, golfcart.CapabilityImport)
+	if err != nil {
+		t.Fatalf("NewInterpreterWith: %v", err)
+	}
+	limited.Context().SetLimits(golfcart.Limits{MaxAllocBytes: 1000})
+	_, err = limited.Run(compile(`import("wide.golf")`))
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Run: expected LimitError for a module's exports, got %v", err)
+	}
+}
+
+type cyclicNode struct {
+	Next *cyclicNode
+}
+
+type cyclicList []cyclicList
+
+func TestMarshalCycles(t *testing.T) {
+	interpreter := golfcart.NewInterpreter()
+	result, err := interpreter.Eval(`l = [1] l.append(l) d = {} d.self = d [l, d]`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	var generic interface{}
+	if err := golfcart.FromValue(result, &generic); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Errorf("FromValue: expected a cyclic value error, got %v", err)
+	}
+	var typed cyclicList
+	list, _ := interpreter.GetGlobal("l")
+	if err := golfcart.FromValue(list, &typed); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Errorf("FromValue: expected a cyclic value error, got %v", err)
+	}
+
+	node := &cyclicNode{}
+	node.Next = node
+	m := map[string]interface{}{}
+	m["self"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	for _, v := range []interface{}{node, m, s} {
+		if _, err := golfcart.ToValue(v); err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("ToValue(%T): expected a cyclic value error, got %v", v, err)
+		}
+	}
+
+	shared := []int{1}
+	if _, err := golfcart.ToValue([][]int{shared, shared}); err != nil {
+		t.Errorf("ToValue: expected shared values to convert, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package golfcart
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/healeycodes/golfcart/pkg/golfcart"
@@ -28,3 +29,38 @@ func TestEval(t *testing.T) {
 		t.Errorf("Eval: %v", err)
 	}
 }
+
+func TestAmbiguousForms(t *testing.T) {
+	tests := []struct {
+		program string
+		result  string
+	}{
+		// Commas separate call arguments and list items before patterns
+		{"f = (x, y) => [x, y] a = 5 r = f(a, b = 2) [r, b]", "[[5, 2], 2]"},
+		{"a = 0 l = [a, b = 1] [l, b]", "[[0, 1], 1]"},
+		{"f = (x, y) => [x, y] r = f([a, b] = [1, 2], 3) [r, a, b]", "[[[1, 2], 3], 1, 2]"},
+		{"l = [0, [a, b] = [1, 2]] [l, a, b]", "[[0, [1, 2]], 1, 2]"},
+		// A statement directly after `(` is a whole expression again
+		{"r = (a, b = [1, 2]) [r, a, b]", "[[1, 2], 1, 2]"},
+		{"r = ([a] = [3]) [r, a]", "[[3], 3]"},
+		{"r = (a = 4) [r, a]", "[4, 4]"},
+		{"f = x => x r = f((a, b = [5, 6])) [r, a, b]", "[[5, 6], 5, 6]"},
+		{"f = (a = 7) => a f()", "7"},
+	}
+	for _, test := range tests {
+		result, err := golfcart.NewInterpreter().Eval(test.program)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", test.program, err)
+			continue
+		}
+		if result.String() != test.result {
+			t.Errorf("Eval(%q): expected %v, got %v", test.program, test.result, result)
+		}
+	}
+}
+
+func TestGrammarHasNoGuards(t *testing.T) {
+	if grammar := golfcart.GetGrammer(); strings.Contains(grammar, "Guard") {
+		t.Errorf("GetGrammer: expected no lookahead guards, got:\n%v", grammar)
+	}
+}